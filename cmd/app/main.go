@@ -3,7 +3,9 @@ package main
 import (
 	"log"
 
+	"github.com/3milly4ever/parser-landstar/internal/blobstore"
 	"github.com/3milly4ever/parser-landstar/internal/handler"
+	logger "github.com/3milly4ever/parser-landstar/internal/log"
 	config "github.com/3milly4ever/parser-landstar/pkg"
 	"github.com/aws/aws-lambda-go/lambda"
 )
@@ -11,6 +13,13 @@ import (
 func main() {
 	// Load configuration (assumes internal error handling within LoadConfig)
 	config.LoadConfig()
+	// Apply LOG_LEVEL and LOG_FORMAT
+	logger.Configure()
+
+	// Email bodies are offloaded through the blob store, fail now rather than on the first large email
+	if _, err := blobstore.Default(); err != nil {
+		log.Fatalf("Failed to set up the blob store: %v", err)
+	}
 
 	// Initialize the database
 	db, err := handler.InitializeDB()
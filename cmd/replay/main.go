@@ -0,0 +1,128 @@
+// Command replay lists, inspects, edits and replays messages the worker failed to process.
+//
+//	replay list [-status open] [-class parser_log_not_found] [-limit 50]
+//	replay show <id>
+//	replay edit <id> <file>
+//	replay run <id> [<id>...]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/3milly4ever/parser-landstar/internal/worker"
+	config "github.com/3milly4ever/parser-landstar/pkg"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	// Load configuration, the database DSN comes from the environment
+	config.LoadConfig()
+
+	ctx := context.Background()
+	switch os.Args[1] {
+	case "list":
+		list(ctx, os.Args[2:])
+	case "show":
+		show(ctx, os.Args[2:])
+	case "edit":
+		edit(ctx, os.Args[2:])
+	case "run":
+		run(ctx, os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	log.Fatal("usage: replay list [-status open] [-class CLASS] [-limit N] | show ID | edit ID FILE | run ID...")
+}
+
+func list(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	status := flags.String("status", "open", "only messages in this status, empty for all")
+	class := flags.String("class", "", "only messages with this error class")
+	limit := flags.Int("limit", 50, "maximum number of messages")
+	flags.Parse(args)
+
+	failed, err := worker.ListFailedMessages(ctx, *status, *class, *limit)
+	if err != nil {
+		log.Fatalf("Failed to list failed messages: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tCLASS\tATTEMPTS\tLAST FAILED\tERROR")
+	for _, f := range failed {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\n", f.ID, f.Status, f.ErrorClass, f.Attempts, f.LastFailedAt.Format(time.RFC3339), f.ErrorText)
+	}
+	w.Flush()
+}
+
+func show(ctx context.Context, args []string) {
+	if len(args) != 1 {
+		usage()
+	}
+	failed, err := worker.GetFailedMessage(ctx, parseID(args[0]))
+	if err != nil {
+		log.Fatalf("Failed to load failed message: %v", err)
+	}
+
+	out, _ := json.MarshalIndent(failed, "", "  ")
+	fmt.Println(string(out))
+}
+
+func edit(ctx context.Context, args []string) {
+	if len(args) != 2 {
+		usage()
+	}
+	body, err := os.ReadFile(args[1])
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", args[1], err)
+	}
+	if err := worker.EditFailedMessage(ctx, parseID(args[0]), string(body)); err != nil {
+		log.Fatalf("Failed to edit failed message: %v", err)
+	}
+	fmt.Println("Updated message body")
+}
+
+func run(ctx context.Context, args []string) {
+	if len(args) == 0 {
+		usage()
+	}
+
+	failures := 0
+	for _, arg := range args {
+		id := parseID(arg)
+		replayCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+		err := worker.ReplayFailedMessage(replayCtx, id)
+		cancel()
+		if err != nil {
+			log.Printf("%d: replay failed: %v", id, err)
+			failures++
+			continue
+		}
+		fmt.Printf("%d: replayed\n", id)
+	}
+	if failures > 0 {
+		os.Exit(1)
+	}
+}
+
+func parseID(value string) int {
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid id %q", value)
+	}
+	return id
+}
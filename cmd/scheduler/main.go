@@ -0,0 +1,19 @@
+// Command scheduler is the Lambda an EventBridge schedule invokes to run the periodic jobs
+// (outbox delivery, re-geocoding, drift evaluation and pruning) when the server is not deployed.
+package main
+
+import (
+	logger "github.com/3milly4ever/parser-landstar/internal/log"
+	"github.com/3milly4ever/parser-landstar/internal/worker"
+	config "github.com/3milly4ever/parser-landstar/pkg"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+func main() {
+	// Load configuration
+	config.LoadConfig()
+	// Apply LOG_LEVEL and LOG_FORMAT, the Lambda logs to stdout only
+	logger.Configure()
+
+	lambda.Start(worker.ScheduledHandler)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/3milly4ever/parser-landstar/internal/blobstore"
+	logger "github.com/3milly4ever/parser-landstar/internal/log"
+	"github.com/3milly4ever/parser-landstar/internal/metrics"
+	"github.com/3milly4ever/parser-landstar/internal/tracing"
+	"github.com/3milly4ever/parser-landstar/internal/worker"
+	config "github.com/3milly4ever/parser-landstar/pkg"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+func main() {
+	// Inside Lambda the runtime API is set, anywhere else default to polling the queue
+	defaultMode := "poll"
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		defaultMode = "lambda"
+	}
+	mode := flag.String("mode", defaultMode, "lambda to run as the SQS Lambda handler, poll to run as a long running worker")
+	flag.Parse()
+
+	// Load configuration
+	config.LoadConfig()
+
+	// The worker reads offloaded email bodies, fail now rather than on every message
+	if _, err := blobstore.Default(); err != nil {
+		log.Fatalf("Failed to set up the blob store: %v", err)
+	}
+
+	switch *mode {
+	case "lambda":
+		// Apply LOG_LEVEL and LOG_FORMAT, the Lambda logs to stdout only
+		logger.Configure()
+		lambda.Start(worker.LambdaHandler)
+	case "poll":
+		logger.InitLogger()
+
+		// Only a long running process can be scraped
+		if err := metrics.Serve(config.AppConfig.MetricsAddr); err != nil {
+			log.Fatal(err)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := worker.RunPoller(ctx); err != nil {
+			log.Fatalf("Worker failed: %v", err)
+		}
+		// Export the spans still buffered before exiting
+		tracing.Shutdown(context.Background())
+	default:
+		log.Fatalf("Unknown mode %q", *mode)
+	}
+}
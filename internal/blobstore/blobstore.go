@@ -0,0 +1,71 @@
+// Package blobstore keeps large payloads such as email bodies out of SQS messages.
+package blobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	config "github.com/3milly4ever/parser-landstar/pkg"
+)
+
+// ErrNotFound is returned when a key does not exist in the store
+var ErrNotFound = errors.New("blob not found")
+
+// Store saves and loads blobs by key
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+var (
+	defaultStore Store
+	defaultErr   error
+	defaultOnce  sync.Once
+)
+
+// Default returns the process wide store, created from the configuration on first use.
+// Binaries call it at startup so a misconfigured store fails before any message is accepted.
+func Default() (Store, error) {
+	defaultOnce.Do(func() {
+		defaultStore, defaultErr = New()
+	})
+	return defaultStore, defaultErr
+}
+
+// New returns the store selected by BLOB_STORE
+func New() (Store, error) {
+	switch config.AppConfig.BlobStore {
+	case "", "local":
+		// Every Lambda function has its own throwaway filesystem, the worker could never read the files
+		if config.OnLambda() {
+			return nil, errors.New("the local blob store does not work on Lambda, set BLOB_STORE=s3 and BLOB_S3_BUCKET")
+		}
+		return NewLocalStore(config.AppConfig.BlobLocalDir), nil
+	case "s3":
+		return NewS3Store(config.AppConfig.AWSRegion, config.AppConfig.BlobS3Bucket, config.AppConfig.BlobS3Prefix)
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", config.AppConfig.BlobStore)
+	}
+}
+
+// Checksum returns the hex encoded SHA-256 of data
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// GetVerified loads a blob and checks it against the checksum it was stored with
+func GetVerified(ctx context.Context, store Store, key, checksum string) ([]byte, error) {
+	data, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if got := Checksum(data); got != checksum {
+		return nil, fmt.Errorf("checksum mismatch for %s: got %s, want %s", key, got, checksum)
+	}
+	return data, nil
+}
@@ -0,0 +1,64 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	config "github.com/3milly4ever/parser-landstar/pkg"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		store    string
+		onLambda bool
+		wantErr  bool
+	}{
+		{name: "local", store: "local"},
+		{name: "empty means local", store: ""},
+		{name: "local on lambda", store: "local", onLambda: true, wantErr: true},
+		{name: "s3 without bucket", store: "s3", wantErr: true},
+		{name: "unknown store", store: "ftp", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.AppConfig.BlobStore = tt.store
+			config.AppConfig.BlobLocalDir = t.TempDir()
+			config.AppConfig.BlobS3Bucket = ""
+			if tt.onLambda {
+				t.Setenv("AWS_LAMBDA_FUNCTION_NAME", "email-parser")
+			}
+
+			_, err := New()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalStore(t.TempDir())
+	data := []byte("<p>load</p>")
+
+	if err := store.Put(ctx, "parser-log/1/body.html", data); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	got, err := GetVerified(ctx, store, "parser-log/1/body.html", Checksum(data))
+	if err != nil || string(got) != string(data) {
+		t.Fatalf("GetVerified() = %q, %v", got, err)
+	}
+
+	if _, err := GetVerified(ctx, store, "parser-log/1/body.html", Checksum([]byte("other"))); err == nil {
+		t.Error("GetVerified() accepted a wrong checksum")
+	}
+	if _, err := store.Get(ctx, "parser-log/2/body.html"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of a missing key error = %v, want %v", err, ErrNotFound)
+	}
+	if err := store.Put(ctx, "../escape", data); err == nil {
+		t.Error("Put() accepted a key outside the store")
+	}
+}
@@ -0,0 +1,172 @@
+// Package contract defines the message the handler publishes to SQS and the worker consumes.
+// Both sides must build and read messages through these types so the two can never drift apart.
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SchemaVersion is the version of OrderMessage written by the handler.
+// Bump it whenever a field is added, removed or changes meaning.
+//
+// Version 2 moves email bodies larger than BLOB_OFFLOAD_THRESHOLD out of the message into the blob store.
+const SchemaVersion = 2
+
+// MinSchemaVersion is the oldest version the worker still accepts, so messages
+// already queued during a deploy are not lost. Messages without a schemaVersion
+// predate the contract and are read as version 1.
+const MinSchemaVersion = 1
+
+// Actions a message can ask the worker to perform
+const (
+	ActionNew    = "new"
+	ActionUpdate = "update"
+	ActionCancel = "cancel"
+)
+
+var (
+	ErrMalformed          = errors.New("malformed message")
+	ErrUnsupportedVersion = errors.New("unsupported schema version")
+	ErrInvalid            = errors.New("invalid message")
+)
+
+// OrderMessage is the body of every SQS message sent from the handler to the worker
+type OrderMessage struct {
+	SchemaVersion      int       `json:"schemaVersion"`
+	Action             string    `json:"action"`
+	ParserLogID        int       `json:"parserLogID"`
+	OrderNumber        string    `json:"orderNumber"`
+	OrderTypeID        int       `json:"orderTypeID"`
+	TruckTypeID        int       `json:"truckTypeID"`
+	SuggestedTruckSize string    `json:"suggestedTruckSize"`
+	OriginalTruckSize  string    `json:"originalTruckSize"`
+	Notes              string    `json:"notes"`
+	PickupDate         time.Time `json:"pickupDate"`
+	DeliveryDate       time.Time `json:"deliveryDate"`
+	EstimatedMiles     int       `json:"estimatedMiles"`
+	Pickup             Location  `json:"pickup"`
+	Delivery           Location  `json:"delivery"`
+	Item               Item      `json:"item"`
+	Email              Email     `json:"email"`
+	CreatedAt          time.Time `json:"createdAt"`
+}
+
+// Location is a pickup or delivery stop
+type Location struct {
+	Label       string `json:"label"`
+	Street      string `json:"street"`
+	City        string `json:"city"`
+	State       string `json:"state"`
+	StateCode   string `json:"stateCode"`
+	PostalCode  string `json:"postalCode"`
+	CountryCode string `json:"countryCode"`
+	CountryName string `json:"countryName"`
+}
+
+// Item holds the freight dimensions
+type Item struct {
+	Length    float64 `json:"length"`
+	Width     float64 `json:"width"`
+	Height    float64 `json:"height"`
+	Weight    float64 `json:"weight"`
+	Pieces    int     `json:"pieces"`
+	Stackable bool    `json:"stackable"`
+	Hazardous bool    `json:"hazardous"`
+}
+
+// Email holds the details of the email the order came from.
+// Since version 2 a large body is stored in the blob store and only referenced here,
+// a body is either inline or referenced, never both.
+type Email struct {
+	Subject      string   `json:"subject"`
+	MessageID    string   `json:"messageID"`
+	ReplyTo      string   `json:"replyTo"`
+	BodyHTML     string   `json:"bodyHTML,omitempty"`
+	BodyPlain    string   `json:"bodyPlain,omitempty"`
+	BodyHTMLRef  *BlobRef `json:"bodyHTMLRef,omitempty"`
+	BodyPlainRef *BlobRef `json:"bodyPlainRef,omitempty"`
+}
+
+// BlobRef points at a payload kept in the blob store
+type BlobRef struct {
+	Key    string `json:"key"`
+	SHA256 string `json:"sha256"`
+	Size   int    `json:"size"`
+}
+
+// Encode stamps the current schema version and marshals the message
+func Encode(msg *OrderMessage) ([]byte, error) {
+	msg.SchemaVersion = SchemaVersion
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(msg)
+}
+
+// Decode strictly parses a message body.
+// Unknown fields, wrong types, trailing data and unsupported versions are all rejected,
+// except on unversioned legacy messages which are converted to version 1.
+func Decode(body []byte) (*OrderMessage, error) {
+	var version struct {
+		SchemaVersion *int `json:"schemaVersion"`
+	}
+	if err := json.Unmarshal(body, &version); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if version.SchemaVersion == nil {
+		msg, err := decodeLegacy(body)
+		if err != nil {
+			return nil, err
+		}
+		if err := msg.Validate(); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+
+	var msg OrderMessage
+	if err := decoder.Decode(&msg); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("%w: unexpected data after message", ErrMalformed)
+	}
+
+	if msg.SchemaVersion < MinSchemaVersion || msg.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("%w: got %d, want %d to %d", ErrUnsupportedVersion, msg.SchemaVersion, MinSchemaVersion, SchemaVersion)
+	}
+
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// Validate checks the fields every message must carry
+func (m *OrderMessage) Validate() error {
+	switch m.Action {
+	case ActionNew, ActionUpdate, ActionCancel:
+	default:
+		return fmt.Errorf("%w: unknown action %q", ErrInvalid, m.Action)
+	}
+	if m.ParserLogID <= 0 {
+		return fmt.Errorf("%w: parserLogID is required", ErrInvalid)
+	}
+	if m.Action == ActionCancel && m.OrderNumber == "" {
+		return fmt.Errorf("%w: orderNumber is required to cancel an order", ErrInvalid)
+	}
+	for _, ref := range []*BlobRef{m.Email.BodyHTMLRef, m.Email.BodyPlainRef} {
+		if ref != nil && (ref.Key == "" || ref.SHA256 == "") {
+			return fmt.Errorf("%w: body reference needs a key and a checksum", ErrInvalid)
+		}
+	}
+	return nil
+}
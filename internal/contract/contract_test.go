@@ -0,0 +1,181 @@
+package contract
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr error
+		version int
+		action  string
+	}{
+		{
+			name:    "current version",
+			body:    `{"schemaVersion":2,"action":"new","parserLogID":1}`,
+			version: 2,
+			action:  ActionNew,
+		},
+		{
+			name:    "oldest supported version",
+			body:    `{"schemaVersion":1,"action":"update","parserLogID":1,"email":{"bodyPlain":"hello"}}`,
+			version: 1,
+			action:  ActionUpdate,
+		},
+		{
+			name:    "unversioned legacy message",
+			body:    `{"orderNumber":"123","pickupLocation":"75001, Dallas, Texas, US","pickupCity":"Dallas","parserLogID":7,"bodyPlain":"hello"}`,
+			version: 1,
+			action:  ActionNew,
+		},
+		{
+			name:    "unversioned legacy cancellation",
+			body:    `{"action":"cancel","orderNumber":"123","parserLogID":7}`,
+			version: 1,
+			action:  ActionCancel,
+		},
+		{
+			name:    "version zero",
+			body:    `{"schemaVersion":0,"action":"new","parserLogID":1}`,
+			wantErr: ErrUnsupportedVersion,
+		},
+		{
+			name:    "version from the future",
+			body:    `{"schemaVersion":3,"action":"new","parserLogID":1}`,
+			wantErr: ErrUnsupportedVersion,
+		},
+		{
+			name:    "unknown field",
+			body:    `{"schemaVersion":2,"action":"new","parserLogID":1,"pickupLocation":"Dallas"}`,
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "wrong type",
+			body:    `{"schemaVersion":2,"action":"new","parserLogID":"1"}`,
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "trailing data",
+			body:    `{"schemaVersion":2,"action":"new","parserLogID":1} {}`,
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "not json",
+			body:    `parserLogID=1`,
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "unknown action",
+			body:    `{"schemaVersion":2,"action":"delete","parserLogID":1}`,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "missing parser log",
+			body:    `{"schemaVersion":2,"action":"new"}`,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "cancellation without order number",
+			body:    `{"schemaVersion":2,"action":"cancel","parserLogID":1}`,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "body reference without checksum",
+			body:    `{"schemaVersion":2,"action":"new","parserLogID":1,"email":{"bodyHTMLRef":{"key":"a"}}}`,
+			wantErr: ErrInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := Decode([]byte(tt.body))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Decode() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if msg.SchemaVersion != tt.version || msg.Action != tt.action {
+				t.Errorf("Decode() = version %d action %q, want version %d action %q", msg.SchemaVersion, msg.Action, tt.version, tt.action)
+			}
+		})
+	}
+}
+
+func TestDecodeLegacyFields(t *testing.T) {
+	msg, err := Decode([]byte(`{
+		"action": "new",
+		"orderNumber": "82163",
+		"orderTypeID": 4,
+		"truckTypeID": 1,
+		"pickupLocation": "Monterey, California, United States",
+		"pickupStreet": "1 Cannery Row",
+		"pickupZip": "93940",
+		"pickupCity": "Monterey",
+		"pickupState": "California",
+		"pickupStateCode": "CA",
+		"pickupCountryCode": "US",
+		"deliveryLocation": "89030, North Las Vegas, Nevada, US",
+		"deliveryStreet": "2 Industrial Rd",
+		"deliveryZip": "89030",
+		"deliveryCity": "North Las Vegas",
+		"deliveryStateCode": "NV",
+		"weight": 660,
+		"pieces": 2,
+		"replyTo": "dispatch@example.com",
+		"subject": "SMALL STRAIGHT from MONTEREY, CA",
+		"bodyHTML": "<p>load</p>",
+		"messageID": "<abc@example.com>",
+		"parserLogID": 12,
+		"updatedAt": "2024-05-01T10:00:00Z"
+	}`))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	if msg.OrderNumber != "82163" || msg.OrderTypeID != 4 || msg.TruckTypeID != 1 || msg.ParserLogID != 12 {
+		t.Errorf("order fields = %+v", msg)
+	}
+	if msg.Pickup.PostalCode != "93940" || msg.Pickup.City != "Monterey" || msg.Pickup.StateCode != "CA" || msg.Pickup.CountryCode != "US" {
+		t.Errorf("pickup = %+v", msg.Pickup)
+	}
+	if msg.Pickup.Label != "Monterey, California, United States" || msg.Pickup.Street != "1 Cannery Row" {
+		t.Errorf("pickup label and street = %+v", msg.Pickup)
+	}
+	if msg.Delivery.PostalCode != "89030" || msg.Delivery.City != "North Las Vegas" || msg.Delivery.StateCode != "NV" {
+		t.Errorf("delivery = %+v", msg.Delivery)
+	}
+	if msg.Delivery.Label != "89030, North Las Vegas, Nevada, US" || msg.Delivery.Street != "2 Industrial Rd" {
+		t.Errorf("delivery label and street = %+v", msg.Delivery)
+	}
+	if msg.Item.Weight != 660 || msg.Item.Pieces != 2 {
+		t.Errorf("item = %+v", msg.Item)
+	}
+	if msg.Email.BodyHTML != "<p>load</p>" || msg.Email.ReplyTo != "dispatch@example.com" || msg.Email.MessageID != "<abc@example.com>" {
+		t.Errorf("email = %+v", msg.Email)
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	body, err := Encode(&OrderMessage{Action: ActionNew, ParserLogID: 3, OrderNumber: "42"})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	msg, err := Decode(body)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if msg.SchemaVersion != SchemaVersion || msg.OrderNumber != "42" {
+		t.Errorf("round trip = %+v", msg)
+	}
+
+	if _, err := Encode(&OrderMessage{Action: ActionNew}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Encode() of an invalid message error = %v, want %v", err, ErrInvalid)
+	}
+}
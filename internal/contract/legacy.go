@@ -0,0 +1,122 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// legacyMessage is the flat map the handler published before the contract existed.
+// Such messages carry no schemaVersion and may still be queued during a deploy.
+type legacyMessage struct {
+	Action             string    `json:"action"`
+	OrderNumber        string    `json:"orderNumber"`
+	OrderTypeID        int       `json:"orderTypeID"`
+	TruckTypeID        int       `json:"truckTypeID"`
+	SuggestedTruckSize string    `json:"suggestedTruckSize"`
+	OriginalTruckSize  string    `json:"originalTruckSize"`
+	Notes              string    `json:"notes"`
+	PickupDate         time.Time `json:"pickupDate"`
+	DeliveryDate       time.Time `json:"deliveryDate"`
+	EstimatedMiles     int       `json:"estimatedMiles"`
+
+	PickupLocation      string `json:"pickupLocation"`
+	PickupStreet        string `json:"pickupStreet"`
+	PickupZip           string `json:"pickupZip"`
+	PickupCity          string `json:"pickupCity"`
+	PickupState         string `json:"pickupState"`
+	PickupStateCode     string `json:"pickupStateCode"`
+	PickupCountryCode   string `json:"pickupCountryCode"`
+	PickupCountryName   string `json:"pickupCountryName"`
+	DeliveryLocation    string `json:"deliveryLocation"`
+	DeliveryStreet      string `json:"deliveryStreet"`
+	DeliveryZip         string `json:"deliveryZip"`
+	DeliveryCity        string `json:"deliveryCity"`
+	DeliveryState       string `json:"deliveryState"`
+	DeliveryStateCode   string `json:"deliveryStateCode"`
+	DeliveryCountryCode string `json:"deliveryCountryCode"`
+	DeliveryCountryName string `json:"deliveryCountryName"`
+
+	Length    float64 `json:"length"`
+	Width     float64 `json:"width"`
+	Height    float64 `json:"height"`
+	Weight    float64 `json:"weight"`
+	Pieces    int     `json:"pieces"`
+	Stackable bool    `json:"stackable"`
+	Hazardous bool    `json:"hazardous"`
+
+	ReplyTo     string    `json:"replyTo"`
+	Subject     string    `json:"subject"`
+	BodyHTML    string    `json:"bodyHTML"`
+	BodyPlain   string    `json:"bodyPlain"`
+	MessageID   string    `json:"messageID"`
+	ParserLogID int       `json:"parserLogID"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// decodeLegacy reads an unversioned message as version 1, which also carried the bodies inline.
+// The legacy map had fields the contract dropped, so unknown fields are ignored here.
+func decodeLegacy(body []byte) (*OrderMessage, error) {
+	var legacy legacyMessage
+	if err := json.Unmarshal(body, &legacy); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	// Messages without an action predate update/cancel handling and are always new loads
+	action := legacy.Action
+	if action == "" {
+		action = ActionNew
+	}
+
+	return &OrderMessage{
+		SchemaVersion:      1,
+		Action:             action,
+		ParserLogID:        legacy.ParserLogID,
+		OrderNumber:        legacy.OrderNumber,
+		OrderTypeID:        legacy.OrderTypeID,
+		TruckTypeID:        legacy.TruckTypeID,
+		SuggestedTruckSize: legacy.SuggestedTruckSize,
+		OriginalTruckSize:  legacy.OriginalTruckSize,
+		Notes:              legacy.Notes,
+		PickupDate:         legacy.PickupDate,
+		DeliveryDate:       legacy.DeliveryDate,
+		EstimatedMiles:     legacy.EstimatedMiles,
+		Pickup: Location{
+			Label:       legacy.PickupLocation,
+			Street:      legacy.PickupStreet,
+			City:        legacy.PickupCity,
+			State:       legacy.PickupState,
+			StateCode:   legacy.PickupStateCode,
+			PostalCode:  legacy.PickupZip,
+			CountryCode: legacy.PickupCountryCode,
+			CountryName: legacy.PickupCountryName,
+		},
+		Delivery: Location{
+			Label:       legacy.DeliveryLocation,
+			Street:      legacy.DeliveryStreet,
+			City:        legacy.DeliveryCity,
+			State:       legacy.DeliveryState,
+			StateCode:   legacy.DeliveryStateCode,
+			PostalCode:  legacy.DeliveryZip,
+			CountryCode: legacy.DeliveryCountryCode,
+			CountryName: legacy.DeliveryCountryName,
+		},
+		Item: Item{
+			Length:    legacy.Length,
+			Width:     legacy.Width,
+			Height:    legacy.Height,
+			Weight:    legacy.Weight,
+			Pieces:    legacy.Pieces,
+			Stackable: legacy.Stackable,
+			Hazardous: legacy.Hazardous,
+		},
+		Email: Email{
+			Subject:   legacy.Subject,
+			MessageID: legacy.MessageID,
+			ReplyTo:   legacy.ReplyTo,
+			BodyHTML:  legacy.BodyHTML,
+			BodyPlain: legacy.BodyPlain,
+		},
+		CreatedAt: legacy.CreatedAt,
+	}, nil
+}
@@ -0,0 +1,139 @@
+// Package dbtest opens gorm on an in-memory connection for tests that have no MySQL.
+//
+// The connection records every statement with its arguments and answers queries from
+// canned rows, so a test can check what code under test wrote without a database.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Statement is one query or exec the connection received
+type Statement struct {
+	Query string
+	Args  []driver.Value
+}
+
+// Conn records statements and answers queries, every exec affects one row
+type Conn struct {
+	mu         sync.Mutex
+	statements []Statement
+	rows       []cannedRows
+}
+
+type cannedRows struct {
+	contains string
+	columns  []string
+	values   [][]driver.Value
+}
+
+// Open opens gorm on a new Conn
+func Open(t *testing.T) (*gorm.DB, *Conn) {
+	t.Helper()
+	conn := &Conn{}
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: sql.OpenDB(conn), SkipInitializeWithVersion: true}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("gorm.Open() error = %v", err)
+	}
+	return db, conn
+}
+
+// AddRows answers queries containing the text with the rows, other queries find nothing
+func (c *Conn) AddRows(contains string, columns []string, values ...[]driver.Value) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rows = append(c.rows, cannedRows{contains: contains, columns: columns, values: values})
+}
+
+// Executed returns the statements starting with the prefix, e.g. "INSERT INTO `outbox`"
+func (c *Conn) Executed(prefix string) []Statement {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var matched []Statement
+	for _, statement := range c.statements {
+		if strings.HasPrefix(statement.Query, prefix) {
+			matched = append(matched, statement)
+		}
+	}
+	return matched
+}
+
+func (c *Conn) record(query string, args []driver.Value) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statements = append(c.statements, Statement{Query: query, Args: args})
+}
+
+func (c *Conn) query(query string) driver.Rows {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, canned := range c.rows {
+		if strings.Contains(query, canned.contains) {
+			return &rows{columns: canned.columns, values: canned.values}
+		}
+	}
+	return &rows{}
+}
+
+// Connect and Driver make Conn a driver.Connector, every connection is the same Conn
+func (c *Conn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *Conn) Driver() driver.Driver                        { return c }
+func (c *Conn) Open(string) (driver.Conn, error)             { return c, nil }
+
+func (c *Conn) Prepare(query string) (driver.Stmt, error) { return stmt{conn: c, query: query}, nil }
+func (c *Conn) Close() error                              { return nil }
+func (c *Conn) Begin() (driver.Tx, error)                 { return c, nil }
+func (c *Conn) Commit() error                             { return nil }
+func (c *Conn) Rollback() error                           { return nil }
+
+type stmt struct {
+	conn  *Conn
+	query string
+}
+
+func (s stmt) Close() error  { return nil }
+func (s stmt) NumInput() int { return -1 }
+
+func (s stmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.conn.record(s.query, args)
+	return result{}, nil
+}
+
+func (s stmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.conn.record(s.query, args)
+	return s.conn.query(s.query), nil
+}
+
+type result struct{}
+
+func (result) LastInsertId() (int64, error) { return 1, nil }
+func (result) RowsAffected() (int64, error) { return 1, nil }
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,87 @@
+// Package distance estimates road miles between two coordinates when the email does not state them.
+package distance
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+
+	config "github.com/3milly4ever/parser-landstar/pkg"
+	"github.com/sirupsen/logrus"
+)
+
+const earthRadiusMiles = 3958.8
+
+// Methods an estimate can be produced with
+const (
+	MethodRouter      = "router"
+	MethodGreatCircle = "great_circle"
+)
+
+// Point is a latitude/longitude pair
+type Point struct {
+	Lat float64
+	Lng float64
+}
+
+// IsZero reports whether the point has no coordinates, i.e. was never geocoded
+func (p Point) IsZero() bool {
+	return p.Lat == 0 && p.Lng == 0
+}
+
+// Router returns the driving distance between two points
+type Router interface {
+	Miles(ctx context.Context, from, to Point) (float64, error)
+}
+
+var (
+	router     Router
+	routerOnce sync.Once
+)
+
+// defaultRouter returns the router selected by ROUTER_PROVIDER, or nil when none is configured
+func defaultRouter() Router {
+	routerOnce.Do(func() {
+		switch config.AppConfig.RouterProvider {
+		case "":
+		case "osrm":
+			router = NewOSRM(config.AppConfig.RouterURL, &http.Client{Timeout: config.AppConfig.GeocoderTimeout})
+		default:
+			logrus.Warnf("Unknown router provider %q, estimating miles from the great-circle distance", config.AppConfig.RouterProvider)
+		}
+	})
+	return router
+}
+
+// GreatCircleMiles is the haversine distance between two points
+func GreatCircleMiles(from, to Point) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+	dLat := toRadians(to.Lat - from.Lat)
+	dLng := toRadians(to.Lng - from.Lng)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(from.Lat))*math.Cos(toRadians(to.Lat))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusMiles * math.Asin(math.Sqrt(a))
+}
+
+// EstimateMiles estimates the road miles between two points.
+// The configured router is asked first; without one, or when it fails, the
+// great-circle distance is multiplied by CIRCUITY_FACTOR to account for roads not being straight.
+func EstimateMiles(ctx context.Context, from, to Point) (int, string, error) {
+	if from.IsZero() || to.IsZero() {
+		return 0, "", fmt.Errorf("both points need coordinates to estimate miles")
+	}
+
+	if r := defaultRouter(); r != nil {
+		miles, err := r.Miles(ctx, from, to)
+		if err == nil {
+			return int(math.Round(miles)), MethodRouter, nil
+		}
+		logrus.Warn("Router failed, estimating miles from the great-circle distance: ", err)
+	}
+
+	miles := GreatCircleMiles(from, to) * config.AppConfig.CircuityFactor
+	return int(math.Round(miles)), MethodGreatCircle, nil
+}
@@ -0,0 +1,60 @@
+package distance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const metersPerMile = 1609.344
+
+// OSRM asks an OSRM server for the driving distance
+type OSRM struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewOSRM creates a router for a server such as http://localhost:5000
+func NewOSRM(baseURL string, client *http.Client) *OSRM {
+	return &OSRM{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  client,
+	}
+}
+
+type osrmResponse struct {
+	Code   string `json:"code"`
+	Routes []struct {
+		Distance float64 `json:"distance"`
+	} `json:"routes"`
+}
+
+func (o *OSRM) Miles(ctx context.Context, from, to Point) (float64, error) {
+	// OSRM takes coordinates as lng,lat
+	fullURL := fmt.Sprintf("%s/route/v1/driving/%f,%f;%f,%f?overview=false", o.baseURL, from.Lng, from.Lat, to.Lng, to.Lat)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create routing request: %w", err)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to make routing request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("routing request failed with status %d", resp.StatusCode)
+	}
+
+	var response osrmResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("failed to decode routing response: %w", err)
+	}
+	if response.Code != "Ok" || len(response.Routes) == 0 {
+		return 0, fmt.Errorf("no route found: %s", response.Code)
+	}
+	return response.Routes[0].Distance / metersPerMile, nil
+}
@@ -0,0 +1,443 @@
+package drift
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/3milly4ever/parser-landstar/internal/fieldstats"
+	models "github.com/3milly4ever/parser-landstar/internal/model"
+	"github.com/3milly4ever/parser-landstar/internal/outbox"
+	"github.com/3milly4ever/parser-landstar/internal/parser"
+	"github.com/3milly4ever/parser-landstar/internal/webhook"
+	config "github.com/3milly4ever/parser-landstar/pkg"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Alert kinds
+const (
+	KindSuccessRate  = "success_rate"
+	KindFillRate     = "fill_rate"
+	KindNewStructure = "new_structure"
+)
+
+// KindDriftAlert is the outbox kind of a queued alert
+const KindDriftAlert = "drift_alert"
+
+// maxSamples is how many parser_log IDs an alert carries
+const maxSamples = 5
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+func init() {
+	outbox.Register(KindDriftAlert, deliverAlert)
+}
+
+// RecordSample stores the structure and outcome of one parse.
+// result may be nil when the parser failed.
+func RecordSample(ctx context.Context, db *gorm.DB, parserLogID int, parserName, bodyHTML, bodyPlain string, result *parser.ParserResult) error {
+	structure := Structure(bodyHTML, bodyPlain)
+
+	var missing []string
+	if result != nil {
+		extracted := fieldstats.Extracted(result)
+		for _, field := range fieldstats.Fields {
+			if !extracted[field] {
+				missing = append(missing, field)
+			}
+		}
+	}
+
+	return db.WithContext(ctx).Create(&models.ParserTemplateSample{
+		Parser:        parserName,
+		ParserLogID:   parserLogID,
+		Fingerprint:   Fingerprint(structure),
+		Structure:     strings.Join(structure, "\n"),
+		Success:       result != nil,
+		MissingFields: strings.Join(missing, ","),
+		CreatedAt:     time.Now(),
+	}).Error
+}
+
+// Alert is posted to DRIFT_ALERT_WEBHOOK_URL
+type Alert struct {
+	Parser       string    `json:"parser"`
+	Kind         string    `json:"kind"`
+	Subject      string    `json:"subject"`
+	Message      string    `json:"message"`
+	Current      float64   `json:"current,omitempty"`
+	Baseline     float64   `json:"baseline,omitempty"`
+	Samples      int       `json:"samples"`
+	Added        []string  `json:"added,omitempty"`
+	Removed      []string  `json:"removed,omitempty"`
+	ParserLogIDs []int     `json:"parser_log_ids"`
+	DetectedAt   time.Time `json:"detected_at"`
+}
+
+// Evaluate compares every parser's recent window with its baseline and raises the alerts it finds.
+// An alert for the same parser, kind and subject is not repeated within DRIFT_ALERT_COOLDOWN.
+func Evaluate(ctx context.Context, db *gorm.DB) ([]Alert, error) {
+	db = db.WithContext(ctx)
+	cfg := config.AppConfig
+	now := time.Now()
+	windowStart := now.Add(-cfg.DriftWindow)
+	baselineStart := windowStart.Add(-cfg.DriftBaseline)
+
+	var alerts []Alert
+
+	successAlerts, err := evaluateSuccessRates(db, windowStart, baselineStart)
+	if err != nil {
+		return nil, err
+	}
+	alerts = append(alerts, successAlerts...)
+
+	fillAlerts, err := evaluateFillRates(ctx, db, windowStart)
+	if err != nil {
+		return nil, err
+	}
+	alerts = append(alerts, fillAlerts...)
+
+	structureAlerts, err := evaluateStructures(db, windowStart, baselineStart)
+	if err != nil {
+		return nil, err
+	}
+	alerts = append(alerts, structureAlerts...)
+
+	var raised []Alert
+	for _, alert := range alerts {
+		alert.DetectedAt = now
+		ok, err := raise(db, alert)
+		if err != nil {
+			return raised, err
+		}
+		if ok {
+			raised = append(raised, alert)
+		}
+	}
+	return raised, nil
+}
+
+type rateRow struct {
+	Parser    string
+	Total     int
+	Succeeded int
+}
+
+func evaluateSuccessRates(db *gorm.DB, windowStart, baselineStart time.Time) ([]Alert, error) {
+	rates := func(from, to time.Time) (map[string]rateRow, error) {
+		var rows []rateRow
+		err := db.Model(&models.ParserTemplateSample{}).
+			Select("parser, COUNT(*) AS total, SUM(CASE WHEN success THEN 1 ELSE 0 END) AS succeeded").
+			Where("created_at >= ? AND created_at < ?", from, to).
+			Group("parser").
+			Scan(&rows).Error
+		byParser := make(map[string]rateRow, len(rows))
+		for _, row := range rows {
+			byParser[row.Parser] = row
+		}
+		return byParser, err
+	}
+
+	current, err := rates(windowStart, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	baseline, err := rates(baselineStart, windowStart)
+	if err != nil {
+		return nil, err
+	}
+
+	var alerts []Alert
+	for name, now := range current {
+		base, ok := baseline[name]
+		if !ok || now.Total < config.AppConfig.DriftMinSamples || base.Total < config.AppConfig.DriftMinSamples {
+			continue
+		}
+		rate := float64(now.Succeeded) / float64(now.Total)
+		baseRate := float64(base.Succeeded) / float64(base.Total)
+		if baseRate-rate < config.AppConfig.DriftRateDrop {
+			continue
+		}
+
+		ids, err := sampleIDs(db.Where("success = ?", false), name, windowStart)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, Alert{
+			Parser:       name,
+			Kind:         KindSuccessRate,
+			Subject:      "parse",
+			Message:      fmt.Sprintf("%s parse success rate dropped from %.0f%% to %.0f%%", name, baseRate*100, rate*100),
+			Current:      rate,
+			Baseline:     baseRate,
+			Samples:      now.Total,
+			ParserLogIDs: ids,
+		})
+	}
+	return alerts, nil
+}
+
+func evaluateFillRates(ctx context.Context, db *gorm.DB, windowStart time.Time) ([]Alert, error) {
+	summary, err := fieldstats.Summary(ctx, db, config.AppConfig.DriftWindow, config.AppConfig.DriftBaseline)
+	if err != nil {
+		return nil, err
+	}
+
+	var alerts []Alert
+	for _, rate := range summary {
+		if rate.Change == nil || rate.Total < config.AppConfig.DriftMinSamples || rate.BaselineTotal < config.AppConfig.DriftMinSamples {
+			continue
+		}
+		if -*rate.Change < config.AppConfig.DriftRateDrop {
+			continue
+		}
+
+		ids, err := sampleIDs(db.Where("FIND_IN_SET(?, missing_fields) > 0", rate.Field), rate.Parser, windowStart)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, Alert{
+			Parser:       rate.Parser,
+			Kind:         KindFillRate,
+			Subject:      rate.Field,
+			Message:      fmt.Sprintf("%s %s fill rate dropped from %.0f%% to %.0f%%", rate.Parser, rate.Field, *rate.BaselineRate*100, rate.Rate*100),
+			Current:      rate.Rate,
+			Baseline:     *rate.BaselineRate,
+			Samples:      rate.Total,
+			ParserLogIDs: ids,
+		})
+	}
+	return alerts, nil
+}
+
+type fingerprintRow struct {
+	Parser      string
+	Fingerprint string
+	Total       int
+}
+
+// evaluateStructures alerts on fingerprints seen repeatedly in the window but never in the baseline
+func evaluateStructures(db *gorm.DB, windowStart, baselineStart time.Time) ([]Alert, error) {
+	counts := func(from, to time.Time) ([]fingerprintRow, error) {
+		var rows []fingerprintRow
+		err := db.Model(&models.ParserTemplateSample{}).
+			Select("parser, fingerprint, COUNT(*) AS total").
+			Where("created_at >= ? AND created_at < ? AND fingerprint LIKE ?", from, to, fingerprintVersion+"%").
+			Group("parser, fingerprint").
+			Order("total DESC").
+			Scan(&rows).Error
+		return rows, err
+	}
+
+	current, err := counts(windowStart, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	baseline, err := counts(baselineStart, windowStart)
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[[2]string]bool{}
+	dominant := map[string]string{}
+	for _, row := range baseline {
+		known[[2]string{row.Parser, row.Fingerprint}] = true
+		if _, ok := dominant[row.Parser]; !ok {
+			// Rows are ordered by count, the first one is the usual layout
+			dominant[row.Parser] = row.Fingerprint
+		}
+	}
+
+	var alerts []Alert
+	for _, row := range current {
+		usual, hasBaseline := dominant[row.Parser]
+		if !hasBaseline || known[[2]string{row.Parser, row.Fingerprint}] || row.Total < config.AppConfig.DriftMinStructureSamples {
+			continue
+		}
+
+		added, removed, err := structureDiff(db, row.Parser, usual, row.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		ids, err := sampleIDs(db.Where("fingerprint = ?", row.Fingerprint), row.Parser, windowStart)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, Alert{
+			Parser:       row.Parser,
+			Kind:         KindNewStructure,
+			Subject:      row.Fingerprint,
+			Message:      fmt.Sprintf("%s emails arrived with a new layout %d times", row.Parser, row.Total),
+			Samples:      row.Total,
+			Added:        added,
+			Removed:      removed,
+			ParserLogIDs: ids,
+		})
+	}
+	return alerts, nil
+}
+
+// structureDiff lists the markers a new layout added and removed compared with the usual one
+func structureDiff(db *gorm.DB, parserName, usual, changed string) ([]string, []string, error) {
+	load := func(fingerprint string) (map[string]bool, error) {
+		var sample models.ParserTemplateSample
+		err := db.Where("parser = ? AND fingerprint = ?", parserName, fingerprint).Order("id DESC").First(&sample).Error
+		markers := map[string]bool{}
+		for _, marker := range strings.Split(sample.Structure, "\n") {
+			if marker != "" {
+				markers[marker] = true
+			}
+		}
+		return markers, err
+	}
+
+	before, err := load(usual)
+	if err != nil {
+		return nil, nil, err
+	}
+	after, err := load(changed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var added, removed []string
+	for marker := range after {
+		if !before[marker] {
+			added = append(added, marker)
+		}
+	}
+	for marker := range before {
+		if !after[marker] {
+			removed = append(removed, marker)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed, nil
+}
+
+// sampleIDs returns the most recent parser_log IDs of a parser's samples matching the scope
+func sampleIDs(scope *gorm.DB, parserName string, since time.Time) ([]int, error) {
+	var ids []int
+	err := scope.Model(&models.ParserTemplateSample{}).
+		Where("parser = ? AND created_at >= ?", parserName, since).
+		Order("id DESC").
+		Limit(maxSamples).
+		Pluck("parser_log_id", &ids).Error
+	return ids, err
+}
+
+// raise records the alert and queues its webhook, unless the same alert was raised during the cooldown
+func raise(db *gorm.DB, alert Alert) (bool, error) {
+	var recent int64
+	err := db.Model(&models.DriftAlert{}).
+		Where("parser = ? AND kind = ? AND subject = ? AND created_at >= ?", alert.Parser, alert.Kind, alert.Subject, time.Now().Add(-config.AppConfig.DriftAlertCooldown)).
+		Count(&recent).Error
+	if err != nil || recent > 0 {
+		return false, err
+	}
+
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return false, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"parser":         alert.Parser,
+		"kind":           alert.Kind,
+		"subject":        alert.Subject,
+		"parser_log_ids": alert.ParserLogIDs,
+	}).Warn(alert.Message)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&models.DriftAlert{
+			Parser:    alert.Parser,
+			Kind:      alert.Kind,
+			Subject:   alert.Subject,
+			Payload:   string(payload),
+			CreatedAt: alert.DetectedAt,
+		}).Error; err != nil {
+			return err
+		}
+		if config.AppConfig.DriftAlertWebhookURL == "" {
+			return nil
+		}
+		_, err := outbox.Enqueue(tx, KindDriftAlert, 0, string(payload))
+		return err
+	})
+	return err == nil, err
+}
+
+// deliverAlert posts a queued alert to the configured webhook, signed like the order webhooks
+func deliverAlert(ctx context.Context, db *gorm.DB, msg *models.OutboxMessage) error {
+	url := config.AppConfig.DriftAlertWebhookURL
+	if url == "" {
+		return nil
+	}
+
+	body := []byte(msg.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create drift alert request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret := config.AppConfig.DriftAlertSecret; secret != "" {
+		req.Header.Set(webhook.HeaderSignature, webhook.Sign(secret, time.Now(), body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("drift alert request failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("drift alert webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Run evaluates every DRIFT_INTERVAL until ctx is cancelled
+func Run(ctx context.Context, db *gorm.DB) {
+	if config.AppConfig.DriftInterval <= 0 {
+		logrus.Info("Template drift evaluator disabled")
+		return
+	}
+
+	ticker := time.NewTicker(config.AppConfig.DriftInterval)
+	defer ticker.Stop()
+
+	logrus.Infof("Template drift evaluator started, running every %s", config.AppConfig.DriftInterval)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := RunOnce(ctx, db); err != nil {
+				logrus.Error("Template drift evaluation failed: ", err)
+			}
+		}
+	}
+}
+
+// RunOnce prunes old samples and evaluates once, for Run and the scheduled Lambda
+func RunOnce(ctx context.Context, db *gorm.DB) error {
+	if err := Prune(ctx, db, config.AppConfig.DriftSampleRetention); err != nil {
+		logrus.Error("Failed to prune template samples: ", err)
+	}
+	alerts, err := Evaluate(ctx, db)
+	if len(alerts) > 0 {
+		logrus.Warnf("Raised %d template drift alerts", len(alerts))
+	}
+	return err
+}
+
+// Prune deletes samples older than retention
+func Prune(ctx context.Context, db *gorm.DB, retention time.Duration) error {
+	return db.WithContext(ctx).Where("created_at < ?", time.Now().Add(-retention)).Delete(&models.ParserTemplateSample{}).Error
+}
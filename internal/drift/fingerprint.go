@@ -0,0 +1,87 @@
+// Package drift notices when a broker changes the layout of its load emails.
+//
+// Every parse stores a sample with a fingerprint of the email's structure. A background
+// evaluator compares each parser's recent success rate, field fill rates and structures
+// with its trailing baseline and raises an alert through a webhook when they deviate.
+package drift
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Elements the parsers select, their presence is part of the structure.
+// Other ids are left out, mail clients generate a new one for every message.
+var structureAnchors = []string{
+	"div#stopsDiv",
+	"div#commodityDiv",
+	"table#comments",
+}
+
+// Labels the parsers anchor on, their presence is part of the structure
+var structureLabels = []string{
+	"ORDER NUMBER",
+	"Load #",
+	"Dimensions",
+	"Distance",
+	"Miles",
+	"Total Weight",
+	"Total Pieces",
+	"Requested Vehicle Class",
+	"Hazardous?",
+	"Notes:",
+}
+
+var plainLabelRegex = regexp.MustCompile(`(?m)^\s*([A-Za-z][A-Za-z /#?]{1,30}):`)
+
+// Structure lists the structural markers of an email: the anchors the parsers select,
+// such as div#stopsDiv or table#comments, and the labels the parsers look for.
+// Plain text emails are described by their "Label:" lines.
+func Structure(bodyHTML, bodyPlain string) []string {
+	markers := map[string]bool{}
+
+	text := bodyPlain
+	if bodyHTML != "" {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(bodyHTML))
+		if err == nil {
+			for _, anchor := range structureAnchors {
+				if doc.Find(anchor).Length() > 0 {
+					markers[anchor] = true
+				}
+			}
+			text = doc.Text()
+		}
+	} else {
+		for _, match := range plainLabelRegex.FindAllStringSubmatch(bodyPlain, -1) {
+			markers["label:"+strings.TrimSpace(match[1])] = true
+		}
+	}
+
+	for _, label := range structureLabels {
+		if strings.Contains(text, label) {
+			markers["label:"+label] = true
+		}
+	}
+
+	structure := make([]string, 0, len(markers))
+	for marker := range markers {
+		structure = append(structure, marker)
+	}
+	sort.Strings(structure)
+	return structure
+}
+
+// fingerprintVersion prefixes every fingerprint and changes with the way structures are built,
+// so samples from an older scheme are not mistaken for a new layout
+const fingerprintVersion = "v2:"
+
+// Fingerprint is a short stable hash of a structure
+func Fingerprint(structure []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(structure, "\n")))
+	return fingerprintVersion + hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,194 @@
+// Package fieldstats tracks how often each parser fills in each field of a ParserResult.
+//
+// Every parse records one sample per field: as a metric, whose average is the fill rate,
+// and in hourly buckets of the parser_field_stats table, which the summary compares
+// against the previous days so a template change shows up as a drop in fill rate.
+package fieldstats
+
+import (
+	"context"
+	"time"
+
+	"github.com/3milly4ever/parser-landstar/internal/metrics"
+	models "github.com/3milly4ever/parser-landstar/internal/model"
+	"github.com/3milly4ever/parser-landstar/internal/parser"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Fields are the tracked fields, in the order they are reported
+var Fields = []string{
+	"order_number",
+	"pickup_city",
+	"pickup_state",
+	"pickup_zip",
+	"delivery_city",
+	"delivery_state",
+	"delivery_zip",
+	"pickup_date",
+	"delivery_date",
+	"truck_size",
+	"length",
+	"width",
+	"height",
+	"weight",
+	"pieces",
+	"miles",
+}
+
+// Extracted reports for every tracked field whether the parser filled it in
+func Extracted(result *parser.ParserResult) map[string]bool {
+	return map[string]bool{
+		"order_number":   result.Order.OrderNumber != "",
+		"pickup_city":    result.OrderLocation.PickupCity != "",
+		"pickup_state":   result.OrderLocation.PickupState != "",
+		"pickup_zip":     result.PickupZip != "",
+		"delivery_city":  result.OrderLocation.DeliveryCity != "",
+		"delivery_state": result.OrderLocation.DeliveryState != "",
+		"delivery_zip":   result.DeliveryZip != "",
+		"pickup_date":    !result.Order.PickupDate.IsZero(),
+		"delivery_date":  !result.Order.DeliveryDate.IsZero(),
+		"truck_size":     result.Order.OriginalTruckSize != "" || result.Order.SuggestedTruckSize != "",
+		"length":         result.OrderItem.Length != 0,
+		"width":          result.OrderItem.Width != 0,
+		"height":         result.OrderItem.Height != 0,
+		"weight":         result.OrderItem.Weight != 0,
+		"pieces":         result.OrderItem.Pieces != 0,
+		"miles":          result.Order.EstimatedMiles != 0,
+	}
+}
+
+// Record publishes the fill metrics of one parse and adds it to the current hourly bucket
+func Record(ctx context.Context, db *gorm.DB, parserName string, result *parser.ParserResult) error {
+	extracted := Extracted(result)
+	bucket := time.Now().UTC().Truncate(time.Hour)
+
+	rows := make([]models.ParserFieldStat, 0, len(Fields))
+	for _, field := range Fields {
+		populated := 0
+		if extracted[field] {
+			populated = 1
+		}
+		metrics.ObserveFieldFill(parserName, field, float64(populated))
+
+		rows = append(rows, models.ParserFieldStat{
+			Parser:      parserName,
+			Field:       field,
+			BucketStart: bucket,
+			Total:       1,
+			Populated:   populated,
+		})
+	}
+
+	return db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "parser"}, {Name: "field"}, {Name: "bucket_start"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"total":     gorm.Expr("total + VALUES(total)"),
+			"populated": gorm.Expr("populated + VALUES(populated)"),
+		}),
+	}).Create(&rows).Error
+}
+
+// FillRate compares a field's fill rate over the recent window with the baseline before it
+type FillRate struct {
+	Parser        string   `json:"parser"`
+	Field         string   `json:"field"`
+	Total         int      `json:"total"`
+	Populated     int      `json:"populated"`
+	Rate          float64  `json:"rate"`
+	BaselineTotal int      `json:"baseline_total"`
+	BaselineRate  *float64 `json:"baseline_rate"`
+	Change        *float64 `json:"change"`
+}
+
+type bucketSum struct {
+	Parser    string
+	Field     string
+	Total     int
+	Populated int
+}
+
+// Summary returns the fill rate of every parser and field over the last window,
+// next to the rate over the baseline period just before it
+func Summary(ctx context.Context, db *gorm.DB, window, baseline time.Duration) ([]FillRate, error) {
+	now := time.Now().UTC()
+	windowStart := now.Add(-window).Truncate(time.Hour)
+	baselineStart := windowStart.Add(-baseline)
+
+	sums := func(from, to time.Time) ([]bucketSum, error) {
+		var rows []bucketSum
+		err := db.WithContext(ctx).Model(&models.ParserFieldStat{}).
+			Select("parser, field, SUM(total) AS total, SUM(populated) AS populated").
+			Where("bucket_start >= ? AND bucket_start < ?", from, to).
+			Group("parser, field").
+			Scan(&rows).Error
+		return rows, err
+	}
+
+	current, err := sums(windowStart, now.Add(time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	previous, err := sums(baselineStart, windowStart)
+	if err != nil {
+		return nil, err
+	}
+
+	baselines := make(map[[2]string]bucketSum, len(previous))
+	for _, row := range previous {
+		baselines[[2]string{row.Parser, row.Field}] = row
+	}
+
+	summary := make([]FillRate, 0, len(current))
+	for _, row := range current {
+		if row.Total == 0 {
+			continue
+		}
+		rate := FillRate{
+			Parser:    row.Parser,
+			Field:     row.Field,
+			Total:     row.Total,
+			Populated: row.Populated,
+			Rate:      float64(row.Populated) / float64(row.Total),
+		}
+		if base, ok := baselines[[2]string{row.Parser, row.Field}]; ok && base.Total > 0 {
+			baseRate := float64(base.Populated) / float64(base.Total)
+			change := rate.Rate - baseRate
+			rate.BaselineTotal = base.Total
+			rate.BaselineRate = &baseRate
+			rate.Change = &change
+		}
+		summary = append(summary, rate)
+	}
+	return summary, nil
+}
+
+// Prune deletes buckets older than retention, keeping the table a rolling window
+func Prune(ctx context.Context, db *gorm.DB, retention time.Duration) error {
+	result := db.WithContext(ctx).Where("bucket_start < ?", time.Now().UTC().Add(-retention)).Delete(&models.ParserFieldStat{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		logrus.Infof("Pruned %d field fill-rate buckets", result.RowsAffected)
+	}
+	return nil
+}
+
+// RunPruner prunes old buckets once an hour until ctx is cancelled
+func RunPruner(ctx context.Context, db *gorm.DB, retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if err := Prune(ctx, db, retention); err != nil {
+			logrus.Error("Failed to prune field fill-rate buckets: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package geocode
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/3milly4ever/parser-landstar/internal/log"
+	models "github.com/3milly4ever/parser-landstar/internal/model"
+	config "github.com/3milly4ever/parser-landstar/pkg"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Cached reads forward lookups through an in-process LRU and the geocode_cache table
+// before asking the wrapped geocoder. Addresses the provider has no match for are
+// cached too, for a shorter time, so a bad address does not hit the provider on every message.
+//
+// The cache is best effort: database errors are logged and the lookup goes to the provider.
+// Reverse lookups are not cached.
+type Cached struct {
+	next        Geocoder
+	db          *gorm.DB
+	memory      *lru
+	ttl         time.Duration
+	negativeTTL time.Duration
+	// readOnly keeps new entries in memory only and never writes the geocode_cache table
+	readOnly bool
+}
+
+// NewCached wraps a geocoder with the cache, db may be nil to only cache in memory
+func NewCached(next Geocoder, db *gorm.DB, size int, ttl, negativeTTL time.Duration) *Cached {
+	return &Cached{
+		next:        next,
+		db:          db,
+		memory:      newLRU(size),
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+	}
+}
+
+// UseCache puts the cache configured by GEOCODE_CACHE_* in front of the default geocoder
+func UseCache(db *gorm.DB) error {
+	return useCache(db, false)
+}
+
+// UseReadOnlyCache is UseCache for processes that must not write to MySQL while parsing,
+// lookups still read the geocode_cache table but new entries stay in memory
+func UseReadOnlyCache(db *gorm.DB) error {
+	return useCache(db, true)
+}
+
+// useCache wraps the default geocoder once. When the handler and the worker share a process
+// the cache writes if either of them asked for writes, whichever was initialized first.
+func useCache(db *gorm.DB, readOnly bool) error {
+	geocoder, err := Default()
+	if err != nil {
+		return err
+	}
+	if existing, ok := geocoder.(*Cached); ok {
+		if readOnly || !existing.readOnly {
+			return nil
+		}
+		// Upgrade to a writer around the same provider, keeping what is already in memory
+		writer := *existing
+		writer.db = db
+		writer.readOnly = false
+		SetDefault(&writer)
+		return nil
+	}
+
+	cached := NewCached(geocoder, db, config.AppConfig.GeocodeCacheSize, config.AppConfig.GeocodeCacheTTL, config.AppConfig.GeocodeCacheNegativeTTL)
+	cached.readOnly = readOnly
+	SetDefault(cached)
+	return nil
+}
+
+func (c *Cached) Geocode(ctx context.Context, address string) (*Result, error) {
+	key := NormalizeAddress(address)
+
+	if result, ok := c.memory.get(key); ok {
+		return copyResult(result)
+	}
+
+	if result, expiresAt, ok := c.load(ctx, key); ok {
+		c.memory.put(key, result, expiresAt)
+		return copyResult(result)
+	}
+
+	result, err := c.next.Geocode(ctx, address)
+	switch {
+	case errors.Is(err, ErrNoResults):
+		c.store(ctx, key, nil, time.Now().Add(c.negativeTTL))
+		return nil, err
+	case err != nil:
+		// Provider errors are not cached, the next message tries again
+		return nil, err
+	}
+
+	c.store(ctx, key, result, time.Now().Add(c.ttl))
+	return result, nil
+}
+
+func (c *Cached) Reverse(ctx context.Context, lat, lng float64) (*Result, error) {
+	return c.next.Reverse(ctx, lat, lng)
+}
+
+// load reads an unexpired entry from the geocode_cache table
+func (c *Cached) load(ctx context.Context, key string) (*Result, time.Time, bool) {
+	if c.db == nil {
+		return nil, time.Time{}, false
+	}
+
+	var row models.GeocodeCache
+	err := c.db.WithContext(ctx).Where("address = ? AND expires_at > ?", key, time.Now()).First(&row).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.FromContext(ctx).WithField("address", key).Warn("Failed to read geocode cache: ", err)
+		}
+		return nil, time.Time{}, false
+	}
+
+	if row.NotFound {
+		return nil, row.ExpiresAt, true
+	}
+	return &Result{
+		Lat:        row.Lat,
+		Lng:        row.Lng,
+		County:     row.County,
+		PostalCode: row.PostalCode,
+		Provider:   row.Provider,
+	}, row.ExpiresAt, true
+}
+
+// store saves an entry in memory and, unless the cache is read only, upserts it into the geocode_cache table
+func (c *Cached) store(ctx context.Context, key string, result *Result, expiresAt time.Time) {
+	if result != nil {
+		r := *result
+		c.memory.put(key, &r, expiresAt)
+	} else {
+		c.memory.put(key, nil, expiresAt)
+	}
+	if c.db == nil || c.readOnly {
+		return
+	}
+
+	now := time.Now()
+	row := models.GeocodeCache{
+		Address:   key,
+		NotFound:  result == nil,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if result != nil {
+		row.Lat = result.Lat
+		row.Lng = result.Lng
+		row.County = result.County
+		row.PostalCode = result.PostalCode
+		row.Provider = result.Provider
+	}
+
+	err := c.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "address"}},
+		DoUpdates: clause.AssignmentColumns([]string{"lat", "lng", "county", "postal_code", "provider", "not_found", "expires_at", "updated_at"}),
+	}).Create(&row).Error
+	if err != nil {
+		log.FromContext(ctx).WithField("address", key).Warn("Failed to write geocode cache: ", err)
+	}
+}
+
+// copyResult hands out a copy so callers cannot modify the cached entry,
+// a nil result is a cached miss
+func copyResult(result *Result) (*Result, error) {
+	if result == nil {
+		return nil, ErrNoResults
+	}
+	r := *result
+	return &r, nil
+}
@@ -0,0 +1,43 @@
+package geocode
+
+import "testing"
+
+func TestUseCacheMode(t *testing.T) {
+	tests := []struct {
+		name         string
+		calls        []bool // readOnly of each call, in order
+		wantReadOnly bool
+	}{
+		{name: "read only", calls: []bool{true}, wantReadOnly: true},
+		{name: "writer", calls: []bool{false}},
+		{name: "read only then writer", calls: []bool{true, false}},
+		{name: "writer then read only", calls: []bool{false, true}},
+		{name: "read only twice", calls: []bool{true, true}, wantReadOnly: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewStatic(nil)
+			SetDefault(provider)
+			defer SetDefault(nil)
+
+			for _, readOnly := range tt.calls {
+				if err := useCache(nil, readOnly); err != nil {
+					t.Fatalf("useCache() error = %v", err)
+				}
+			}
+
+			geocoder, _ := Default()
+			cached, ok := geocoder.(*Cached)
+			if !ok {
+				t.Fatalf("Default() = %T, want *Cached", geocoder)
+			}
+			if cached.readOnly != tt.wantReadOnly {
+				t.Errorf("readOnly = %v, want %v", cached.readOnly, tt.wantReadOnly)
+			}
+			if cached.next != provider {
+				t.Errorf("cache wraps %T, want the provider itself", cached.next)
+			}
+		})
+	}
+}
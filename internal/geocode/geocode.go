@@ -0,0 +1,139 @@
+// Package geocode turns addresses into coordinates and back, behind a provider neutral interface.
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	config "github.com/3milly4ever/parser-landstar/pkg"
+)
+
+// ErrNoResults is returned when the provider has no match for the query
+var ErrNoResults = errors.New("no geocoding results")
+
+// Result is the best match for a forward or reverse lookup
+type Result struct {
+	Lat         float64 `json:"lat"`
+	Lng         float64 `json:"lng"`
+	Label       string  `json:"label"`
+	HouseNumber string  `json:"house_number"`
+	Street      string  `json:"street"`
+	City        string  `json:"city"`
+	County      string  `json:"county"`
+	State       string  `json:"state"`
+	StateCode   string  `json:"state_code"`
+	PostalCode  string  `json:"postal_code"`
+	Country     string  `json:"country"`
+	CountryCode string  `json:"country_code"`
+	Provider    string  `json:"provider"`
+}
+
+// Geocoder looks up addresses (forward) and coordinates (reverse)
+type Geocoder interface {
+	Geocode(ctx context.Context, address string) (*Result, error)
+	Reverse(ctx context.Context, lat, lng float64) (*Result, error)
+}
+
+var (
+	defaultGeocoder Geocoder
+	defaultErr      error
+	defaultOnce     sync.Once
+	defaultMu       sync.RWMutex
+)
+
+// Default returns the process wide geocoder, created from the configuration on first use
+func Default() (Geocoder, error) {
+	defaultOnce.Do(func() {
+		g, err := New()
+		defaultMu.Lock()
+		if defaultGeocoder == nil {
+			defaultGeocoder, defaultErr = g, err
+		}
+		defaultMu.Unlock()
+	})
+
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultGeocoder, defaultErr
+}
+
+// SetDefault replaces the process wide geocoder, e.g. with a cached or static one
+func SetDefault(g Geocoder) {
+	defaultOnce.Do(func() {})
+	defaultMu.Lock()
+	defaultGeocoder, defaultErr = g, nil
+	defaultMu.Unlock()
+}
+
+// New creates the geocoder selected by GEOCODER_PROVIDER
+func New() (Geocoder, error) {
+	client := &http.Client{Timeout: config.AppConfig.GeocoderTimeout}
+
+	switch config.AppConfig.GeocoderProvider {
+	case "", "pelias":
+		return instrumented{provider: "pelias", next: NewPelias(config.AppConfig.GeocoderURL, client)}, nil
+	case "nominatim":
+		return instrumented{provider: "nominatim", next: NewNominatim(config.AppConfig.GeocoderURL, client)}, nil
+	case "static":
+		return LoadStatic(config.AppConfig.GeocoderStaticFile)
+	default:
+		return nil, fmt.Errorf("unknown geocoder provider %q", config.AppConfig.GeocoderProvider)
+	}
+}
+
+var (
+	addressPunctuation = regexp.MustCompile(`[.;#]+`)
+	addressSpaces      = regexp.MustCompile(`\s+`)
+)
+
+// NormalizeAddress reduces an address to a canonical form so that
+// "Dallas,  TX" and "dallas, tx." are treated as the same query
+func NormalizeAddress(address string) string {
+	address = strings.ToLower(address)
+	address = addressPunctuation.ReplaceAllString(address, " ")
+	parts := strings.Split(address, ",")
+	cleaned := make([]string, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(addressSpaces.ReplaceAllString(part, " "))
+		if part != "" {
+			cleaned = append(cleaned, part)
+		}
+	}
+	return strings.Join(cleaned, ", ")
+}
+
+// getJSON performs a GET request and decodes a JSON response into out
+func getJSON(ctx context.Context, client *http.Client, fullURL string, header http.Header, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create geocoding request: %w", err)
+	}
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make geocoding request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("geocoding request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode geocoding response: %w", err)
+	}
+	return nil
+}
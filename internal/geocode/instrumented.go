@@ -0,0 +1,63 @@
+package geocode
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/3milly4ever/parser-landstar/internal/metrics"
+	"github.com/3milly4ever/parser-landstar/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumented records the count, result and latency of the requests that reach a provider.
+// It sits below the cache, so cache hits are not counted as provider calls.
+type instrumented struct {
+	provider string
+	next     Geocoder
+}
+
+func (i instrumented) Geocode(ctx context.Context, address string) (*Result, error) {
+	ctx, span := i.start(ctx, "geocode.Geocode")
+	start := time.Now()
+	result, err := i.next.Geocode(ctx, address)
+	metrics.ObserveGeocode(i.provider, resultLabel(err), time.Since(start).Seconds())
+	i.end(span, err)
+	return result, err
+}
+
+func (i instrumented) Reverse(ctx context.Context, lat, lng float64) (*Result, error) {
+	ctx, span := i.start(ctx, "geocode.Reverse")
+	start := time.Now()
+	result, err := i.next.Reverse(ctx, lat, lng)
+	metrics.ObserveGeocode(i.provider, resultLabel(err), time.Since(start).Seconds())
+	i.end(span, err)
+	return result, err
+}
+
+func (i instrumented) start(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("geocode.provider", i.provider)))
+}
+
+// end ends the span, a lookup without results is an answer rather than an error
+func (i instrumented) end(span trace.Span, err error) {
+	span.SetAttributes(attribute.String("geocode.result", resultLabel(err)))
+	if errors.Is(err, ErrNoResults) {
+		err = nil
+	}
+	tracing.End(span, err)
+}
+
+func resultLabel(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrNoResults):
+		return "no_results"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,72 @@
+package geocode
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lru is a small fixed size, least recently used cache of lookups.
+// A nil result is a negative entry.
+type lru struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+}
+
+type lruEntry struct {
+	key       string
+	result    *Result
+	expiresAt time.Time
+}
+
+func newLRU(capacity int) *lru {
+	return &lru{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// get returns the entry for key, ok is false when it is missing or expired
+func (c *lru) get(key string) (result *Result, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, found := c.items[key]
+	if !found {
+		return nil, false
+	}
+	entry := element.Value.(*lruEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.result, true
+}
+
+func (c *lru) put(key string, result *Result, expiresAt time.Time) {
+	if c.capacity <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, found := c.items[key]; found {
+		entry := element.Value.(*lruEntry)
+		entry.result, entry.expiresAt = result, expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, result: result, expiresAt: expiresAt})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
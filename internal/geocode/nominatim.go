@@ -0,0 +1,123 @@
+package geocode
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Nominatim queries an OpenStreetMap Nominatim server
+type Nominatim struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewNominatim creates a Nominatim geocoder for a server such as https://nominatim.openstreetmap.org
+func NewNominatim(baseURL string, client *http.Client) *Nominatim {
+	return &Nominatim{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  client,
+	}
+}
+
+type nominatimPlace struct {
+	Lat         string           `json:"lat"`
+	Lon         string           `json:"lon"`
+	DisplayName string           `json:"display_name"`
+	Address     nominatimAddress `json:"address"`
+}
+
+type nominatimAddress struct {
+	HouseNumber string `json:"house_number"`
+	Road        string `json:"road"`
+	City        string `json:"city"`
+	Town        string `json:"town"`
+	Village     string `json:"village"`
+	County      string `json:"county"`
+	State       string `json:"state"`
+	StateCode   string `json:"ISO3166-2-lvl4"`
+	Postcode    string `json:"postcode"`
+	Country     string `json:"country"`
+	CountryCode string `json:"country_code"`
+}
+
+// Nominatim's usage policy requires an identifying User-Agent
+var nominatimHeader = http.Header{"User-Agent": []string{"parser-landstar"}}
+
+func (n *Nominatim) Geocode(ctx context.Context, address string) (*Result, error) {
+	params := url.Values{}
+	params.Add("q", address)
+	params.Add("format", "jsonv2")
+	params.Add("addressdetails", "1")
+	params.Add("limit", "1")
+
+	var places []nominatimPlace
+	if err := getJSON(ctx, n.client, fmt.Sprintf("%s/search?%s", n.baseURL, params.Encode()), nominatimHeader, &places); err != nil {
+		return nil, err
+	}
+	if len(places) == 0 {
+		return nil, ErrNoResults
+	}
+	return places[0].result()
+}
+
+func (n *Nominatim) Reverse(ctx context.Context, lat, lng float64) (*Result, error) {
+	params := url.Values{}
+	params.Add("lat", strconv.FormatFloat(lat, 'f', -1, 64))
+	params.Add("lon", strconv.FormatFloat(lng, 'f', -1, 64))
+	params.Add("format", "jsonv2")
+	params.Add("addressdetails", "1")
+
+	var place nominatimPlace
+	if err := getJSON(ctx, n.client, fmt.Sprintf("%s/reverse?%s", n.baseURL, params.Encode()), nominatimHeader, &place); err != nil {
+		return nil, err
+	}
+	if place.Lat == "" {
+		return nil, ErrNoResults
+	}
+	return place.result()
+}
+
+func (p nominatimPlace) result() (*Result, error) {
+	lat, err := strconv.ParseFloat(p.Lat, 64)
+	if err != nil {
+		return nil, fmt.Errorf("nominatim returned an invalid latitude %q", p.Lat)
+	}
+	lng, err := strconv.ParseFloat(p.Lon, 64)
+	if err != nil {
+		return nil, fmt.Errorf("nominatim returned an invalid longitude %q", p.Lon)
+	}
+
+	city := p.Address.City
+	if city == "" {
+		city = p.Address.Town
+	}
+	if city == "" {
+		city = p.Address.Village
+	}
+
+	// ISO3166-2-lvl4 looks like "US-TX"
+	stateCode := p.Address.StateCode
+	if i := strings.Index(stateCode, "-"); i >= 0 {
+		stateCode = stateCode[i+1:]
+	}
+
+	return &Result{
+		Lat:         lat,
+		Lng:         lng,
+		Label:       p.DisplayName,
+		HouseNumber: p.Address.HouseNumber,
+		Street:      p.Address.Road,
+		City:        city,
+		County:      p.Address.County,
+		State:       p.Address.State,
+		StateCode:   stateCode,
+		PostalCode:  p.Address.Postcode,
+		Country:     p.Address.Country,
+		CountryCode: strings.ToUpper(p.Address.CountryCode),
+		Provider:    "nominatim",
+	}, nil
+}
@@ -0,0 +1,97 @@
+package geocode
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Pelias queries a Pelias server's /v1/search and /v1/reverse endpoints
+type Pelias struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewPelias creates a Pelias geocoder for a server such as http://localhost:4000
+func NewPelias(baseURL string, client *http.Client) *Pelias {
+	return &Pelias{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  client,
+	}
+}
+
+type peliasResponse struct {
+	Features []peliasFeature `json:"features"`
+}
+
+type peliasFeature struct {
+	Geometry struct {
+		Coordinates []float64 `json:"coordinates"`
+	} `json:"geometry"`
+	Properties peliasProperties `json:"properties"`
+}
+
+type peliasProperties struct {
+	Label       string `json:"label"`
+	HouseNumber string `json:"housenumber"`
+	Street      string `json:"street"`
+	PostalCode  string `json:"postalcode"`
+	Locality    string `json:"locality"`
+	County      string `json:"county"`
+	Region      string `json:"region"`
+	RegionA     string `json:"region_a"`
+	Country     string `json:"country"`
+	CountryA    string `json:"country_a"`
+}
+
+func (p *Pelias) Geocode(ctx context.Context, address string) (*Result, error) {
+	params := url.Values{}
+	params.Add("text", address)
+	params.Add("size", "1") // Limit to the best match
+	return p.lookup(ctx, "/v1/search", params)
+}
+
+func (p *Pelias) Reverse(ctx context.Context, lat, lng float64) (*Result, error) {
+	params := url.Values{}
+	params.Add("point.lat", strconv.FormatFloat(lat, 'f', -1, 64))
+	params.Add("point.lon", strconv.FormatFloat(lng, 'f', -1, 64))
+	params.Add("size", "1")
+	return p.lookup(ctx, "/v1/reverse", params)
+}
+
+func (p *Pelias) lookup(ctx context.Context, path string, params url.Values) (*Result, error) {
+	fullURL := fmt.Sprintf("%s%s?%s", p.baseURL, path, params.Encode())
+
+	var response peliasResponse
+	if err := getJSON(ctx, p.client, fullURL, nil, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Features) == 0 {
+		return nil, ErrNoResults
+	}
+
+	feature := response.Features[0]
+	if len(feature.Geometry.Coordinates) < 2 {
+		return nil, fmt.Errorf("pelias feature has invalid coordinates")
+	}
+
+	props := feature.Properties
+	return &Result{
+		Lng:         feature.Geometry.Coordinates[0],
+		Lat:         feature.Geometry.Coordinates[1],
+		Label:       props.Label,
+		HouseNumber: props.HouseNumber,
+		Street:      props.Street,
+		City:        props.Locality,
+		County:      props.County,
+		State:       props.Region,
+		StateCode:   props.RegionA,
+		PostalCode:  props.PostalCode,
+		Country:     props.Country,
+		CountryCode: props.CountryA,
+		Provider:    "pelias",
+	}, nil
+}
@@ -0,0 +1,66 @@
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// Static answers lookups from a fixed table of addresses.
+// It is meant for tests and for running offline without a geocoding server.
+type Static struct {
+	entries map[string]Result
+}
+
+// NewStatic creates a static geocoder, keys are addresses and are normalized on the way in
+func NewStatic(entries map[string]Result) *Static {
+	normalized := make(map[string]Result, len(entries))
+	for address, result := range entries {
+		result.Provider = "static"
+		normalized[NormalizeAddress(address)] = result
+	}
+	return &Static{entries: normalized}
+}
+
+// LoadStatic reads the table from a JSON file mapping addresses to results.
+// An empty path gives a geocoder that knows no addresses.
+func LoadStatic(path string) (*Static, error) {
+	entries := map[string]Result{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read static geocoder file: %w", err)
+		}
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("failed to parse static geocoder file: %w", err)
+		}
+	}
+	return NewStatic(entries), nil
+}
+
+func (s *Static) Geocode(ctx context.Context, address string) (*Result, error) {
+	result, ok := s.entries[NormalizeAddress(address)]
+	if !ok {
+		return nil, ErrNoResults
+	}
+	return &result, nil
+}
+
+// Reverse returns the known address closest to the coordinates
+func (s *Static) Reverse(ctx context.Context, lat, lng float64) (*Result, error) {
+	var closest *Result
+	best := math.MaxFloat64
+	for _, result := range s.entries {
+		distance := math.Hypot(result.Lat-lat, result.Lng-lng)
+		if distance < best {
+			r := result
+			closest, best = &r, distance
+		}
+	}
+	if closest == nil {
+		return nil, ErrNoResults
+	}
+	return closest, nil
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+// Email is an inbound email, either posted by Mailgun or read from a raw message
+type Email struct {
+	Subject   string `json:"subject"`
+	From      string `json:"from"`
+	Recipient string `json:"recipient"`
+	ReplyTo   string `json:"reply_to"`
+	MessageID string `json:"message_id"`
+	BodyHTML  string `json:"-"`
+	BodyPlain string `json:"-"`
+}
+
+// EmailFromForm reads the fields Mailgun posts to the webhook
+func EmailFromForm(formData url.Values) Email {
+	return Email{
+		Subject:   formData.Get("subject"),
+		From:      formData.Get("from"),
+		Recipient: formData.Get("recipient"),
+		ReplyTo:   formData.Get("reply-to"),
+		MessageID: formData.Get("Message-Id"),
+		BodyHTML:  formData.Get("body-html"),
+		BodyPlain: formData.Get("body-plain"),
+	}
+}
+
+// EmailFromRaw reads an RFC 822 message, collecting the first HTML and plain text parts
+func EmailFromRaw(raw []byte) (Email, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return Email{}, fmt.Errorf("failed to read raw email: %w", err)
+	}
+
+	decoder := new(mime.WordDecoder)
+	decodeHeader := func(name string) string {
+		value := msg.Header.Get(name)
+		if decoded, err := decoder.DecodeHeader(value); err == nil {
+			return decoded
+		}
+		return value
+	}
+
+	email := Email{
+		Subject:   decodeHeader("Subject"),
+		From:      decodeHeader("From"),
+		Recipient: decodeHeader("To"),
+		ReplyTo:   decodeHeader("Reply-To"),
+		MessageID: msg.Header.Get("Message-Id"),
+	}
+
+	err = readPart(&email, msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return Email{}, err
+	}
+	return email, nil
+}
+
+// readPart walks a MIME part, descending into multipart containers
+func readPart(email *Email, contentType, transferEncoding string, body io.Reader) error {
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid content type %q: %w", contentType, err)
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read MIME part: %w", err)
+			}
+			err = readPart(email, part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(transferEncoding) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("failed to read email body: %w", err)
+	}
+
+	switch mediaType {
+	case "text/html":
+		if email.BodyHTML == "" {
+			email.BodyHTML = string(content)
+		}
+	case "text/plain":
+		if email.BodyPlain == "" {
+			email.BodyPlain = string(content)
+		}
+	}
+	return nil
+}
@@ -2,21 +2,31 @@ package handler
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"net/url"
-	"strings"
 	"sync"
 	"time"
 
+	"github.com/3milly4ever/parser-landstar/internal/blobstore"
+	"github.com/3milly4ever/parser-landstar/internal/contract"
+	"github.com/3milly4ever/parser-landstar/internal/drift"
+	"github.com/3milly4ever/parser-landstar/internal/fieldstats"
+	"github.com/3milly4ever/parser-landstar/internal/geocode"
+	"github.com/3milly4ever/parser-landstar/internal/log"
+	"github.com/3milly4ever/parser-landstar/internal/metrics"
 	models "github.com/3milly4ever/parser-landstar/internal/model"
+	"github.com/3milly4ever/parser-landstar/internal/outbox"
 	"github.com/3milly4ever/parser-landstar/internal/parser"
+	"github.com/3milly4ever/parser-landstar/internal/tracing"
+	"github.com/3milly4ever/parser-landstar/internal/webhook"
 	config "github.com/3milly4ever/parser-landstar/pkg"
-	"github.com/PuerkitoBio/goquery"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
@@ -24,11 +34,13 @@ import (
 // Ensure you have access to the 'db' instance
 var (
 	db        *gorm.DB
-	initDB    sync.Once
+	dbMu      sync.Mutex
 	sqsClient *sqs.SQS
 )
 
 func SetDB(database *gorm.DB) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
 	db = database
 
 }
@@ -40,315 +52,399 @@ func init() {
 }
 
 // Initialize AWS session and SQS client in the init function for reuse across Lambda invocations
-// Set connection pooling limits and ensure connection is available throughout the Lambda lifecycle
+// Set connection pooling limits and ensure connection is available throughout the Lambda lifecycle.
+// A failed connection is not kept, the next call tries again, so the server and its
+// readiness check recover once a database that was down at startup comes back.
 func InitializeDB() (*gorm.DB, error) {
-	var err error
-	initDB.Do(func() {
-		dsn := config.AppConfig.MySQLDSN
-		db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
-		if err != nil {
-			logrus.Error("Failed to connect to the database: ", err)
-		}
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	if db != nil {
+		return db, nil
+	}
 
-		// Set connection pooling limits
-		sqlDB, err := db.DB()
-		if err != nil {
-			logrus.Fatalf("Failed to get sql.DB from GORM: %v", err)
+	dsn := config.AppConfig.MySQLDSN
+	database, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	if err != nil {
+		logrus.Error("Failed to connect to the database: ", err)
+		// gorm hands back the pool even when the first ping failed, don't leak it
+		if database != nil {
+			if sqlDB, dbErr := database.DB(); dbErr == nil {
+				sqlDB.Close()
+			}
 		}
-		sqlDB.SetMaxOpenConns(10)
-		sqlDB.SetMaxIdleConns(5)
-		sqlDB.SetConnMaxLifetime(time.Minute * 5)
-	})
+		return nil, err
+	}
 
-	if db == nil {
-		logrus.Error("Database initialization failed. DB is nil.")
+	// Set connection pooling limits
+	sqlDB, err := database.DB()
+	if err != nil {
+		logrus.Error("Failed to get sql.DB from GORM: ", err)
 		return nil, err
 	}
-	return db, err
+	sqlDB.SetMaxOpenConns(10)
+	sqlDB.SetMaxIdleConns(5)
+	sqlDB.SetConnMaxLifetime(time.Minute * 5)
+
+	// The handler writes the outbox, field stats and drift tables, don't wait for a worker to create them
+	if err := models.Migrate(database); err != nil {
+		logrus.Errorf("Failed to migrate database: %v", err)
+	}
+
+	if err := metrics.InstrumentDB(database); err != nil {
+		logrus.Errorf("Failed to instrument database: %v", err)
+	}
+	if err := tracing.InstrumentDB(database); err != nil {
+		logrus.Errorf("Failed to trace database: %v", err)
+	}
+
+	// GetZipCode runs at parse time, read it through the geocode_cache table.
+	// Parsing (and the preview endpoint) must not write to MySQL, the worker fills the table.
+	if err := geocode.UseReadOnlyCache(database); err != nil {
+		logrus.Errorf("Failed to set up the geocode cache: %v", err)
+	}
+
+	db = database
+	return db, nil
 }
 
 func LambdaHandler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	logrus.Info("Mailgun route accessed")
+	defer metrics.Flush()
+	defer tracing.Flush(context.Background())
+
+	// The root span of the email's trace, the worker continues it from the SQS message
+	ctx, span := tracing.Tracer().Start(ctx, "handler.LambdaHandler", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	db := db.WithContext(ctx)
+	logger := log.FromContext(ctx)
+
+	logger.Info("Mailgun route accessed")
 
 	formData, err := url.ParseQuery(request.Body)
 	if err != nil {
-		logrus.Error("Error parsing form data from request body: ", err)
+		logger.Error("Error parsing form data from request body: ", err)
 		return events.APIGatewayProxyResponse{StatusCode: 400, Body: "Invalid form data"}, nil
 	}
 
 	if len(formData) == 0 {
-		logrus.Warn("No data received from Mailgun")
+		logger.Warn("No data received from Mailgun")
 		return events.APIGatewayProxyResponse{StatusCode: 400, Body: "No data received"}, nil
 	}
 
-	subject := formData.Get("subject")
-	bodyHTML := formData.Get("body-html")
-	bodyPlain := formData.Get("body-plain")
-	messageID := formData.Get("Message-Id")
+	email := EmailFromForm(formData)
+
+	// Every log line about this email carries its message ID, and its parser_log ID once we have one
+	ctx = log.WithField(ctx, log.FieldMessageID, email.MessageID)
+	logger = log.FromContext(ctx)
+
+	logger.WithFields(logrus.Fields{
+		"subject":          email.Subject,
+		"recipient":        email.Recipient,
+		"from":             email.From,
+		"body_plain_bytes": len(email.BodyPlain),
+		"body_html_bytes":  len(email.BodyHTML),
+	}).Info("Received email data")
+	// Broker emails are full of names and phone numbers, the bodies are only logged at debug level
+	logger.WithFields(logrus.Fields{
+		"body_plain": email.BodyPlain,
+		"body_html":  email.BodyHTML,
+	}).Debug("Received email bodies")
+
+	// Route and parse first, the route decides which parser the parser_log belongs to
+	parseCtx, parseSpan := tracing.Start(ctx, "handler.ParseEmail", attribute.String("email.message_id", email.MessageID))
+	outcome, parseErr := ParseEmail(parseCtx, email)
+	parseSpan.SetAttributes(
+		attribute.String("parser.name", outcome.Parser),
+		attribute.String("parser.action", string(outcome.Action)),
+		attribute.Bool("parser.accepted", outcome.Accepted),
+	)
+	tracing.End(parseSpan, parseErr)
 
 	parserLog := &models.ParserLog{
-		ParserID:   4,
+		ParserID:   outcome.ParserID,
+		Parser:     outcome.Parser,
 		ParserType: "mail",
-		BodyHtml:   bodyHTML,
-		BodyPlain:  bodyPlain,
+		BodyHtml:   email.BodyHTML,
+		BodyPlain:  email.BodyPlain,
+		Subject:    email.Subject,
 		CreatedAt:  time.Now(),
 		UpdatedAt:  time.Now(),
 	}
+	if parseErr != nil {
+		parserLog.ErrorType = "ParseError"
+		parserLog.ErrorText = parseErr.Error()
+	} else if outcome.Quarantined {
+		parserLog.ErrorType = "Quarantined"
+		parserLog.ErrorText = outcome.Reason
+	}
 
 	if err := db.Create(parserLog).Error; err != nil {
-		logrus.Error("Failed to create parser log record: ", err)
-		parserLog.ErrorType = "ParseError"
-		parserLog.ErrorText = err.Error()
-		parserLog.UpdatedAt = time.Now()
-		db.Save(parserLog)
+		logger.Error("Failed to create parser log record: ", err)
+		tracing.End(span, err)
 		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Failed to create parser log record"}, nil
 	}
+	ctx = log.WithField(ctx, log.FieldParserLogID, parserLog.ID)
+	logger = log.FromContext(ctx)
+
+	if parseErr != nil {
+		logger.Error("Failed to parse email: ", parseErr)
+		// A failed parse is the strongest sign of a template change, the drift evaluator needs it
+		recordParse(ctx, db, parserLog, outcome, email, nil)
+		span.SetAttributes(attribute.Int("parser_log.id", parserLog.ID))
+		tracing.End(span, parseErr)
+		metrics.IncrementMessagesIgnored("parse_error")
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Failed to parse email"}, nil
+	}
 
-	if strings.Contains(bodyHTML, "www.LandstarCarriers.com/Loads") || strings.Contains(bodyPlain, "www.LandstarCarriers.com/Loads") {
-		logrus.WithFields(logrus.Fields{
-			"subject":    subject,
-			"message_id": messageID,
-			"body_html":  bodyHTML,
-			"body_plain": bodyPlain,
-		}).Info("Keyword 'Landstar' found in the email. Request details printed.")
-
-		landstarParser := &parser.LandstarParser{}
-		parserResult, err := landstarParser.Parse(bodyHTML, bodyPlain)
-		if err != nil {
-			logrus.Error("Failed to parse Landstar email: ", err)
-			return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Failed to parse email"}, nil
-		}
+	if outcome.Quarantined {
+		logger.WithFields(logrus.Fields{
+			"recipient": email.Recipient,
+			"from":      email.From,
+		}).Warn("No route matches the email, quarantined")
+		metrics.IncrementMessagesIgnored("quarantined")
+		publishIgnored(ctx, email, outcome, "quarantined")
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: "Email quarantined"}, nil
+	}
 
-		if parserResult == nil {
-			logrus.Warn("ParserResult is nil due to ignored truck size. Deleting parser log and skipping processing.")
-			if err := db.Delete(&parserLog).Error; err != nil {
-				logrus.Error("Failed to delete parser log record: ", err)
-				return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Failed to delete parser log record"}, nil
+	if !outcome.Accepted {
+		if outcome.Action == parser.ActionCancel {
+			logger.Warn("Cancellation email without an order number, nothing to cancel")
+			parserLog.ErrorType = "ParseError"
+			parserLog.ErrorText = outcome.Reason
+			parserLog.UpdatedAt = time.Now()
+			if err := db.Save(parserLog).Error; err != nil {
+				logger.Error("Failed to update parser log record: ", err)
+				return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Failed to update parser log record"}, nil
 			}
-			return events.APIGatewayProxyResponse{StatusCode: 200, Body: "Email ignored due to truck size and parser log deleted"}, nil
+			metrics.IncrementMessagesIgnored("cancel_without_order_number")
+			publishIgnored(ctx, email, outcome, "cancel_without_order_number")
+			return events.APIGatewayProxyResponse{StatusCode: 200, Body: "Cancellation ignored, no order number found"}, nil
 		}
 
-		var replyTo string
-		if bodyPlain != "" {
-			replyTo = parser.ExtractReplyTo(bodyPlain)
+		logger.Warn("ParserResult is nil due to ignored truck size. Deleting parser log and skipping processing.")
+		metrics.IncrementMessagesIgnored("truck_size")
+		if err := db.Delete(parserLog).Error; err != nil {
+			logger.Error("Failed to delete parser log record: ", err)
+			return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Failed to delete parser log record"}, nil
 		}
+		publishIgnored(ctx, email, outcome, "truck_size")
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: "Email ignored due to truck size and parser log deleted"}, nil
+	}
 
-		data := map[string]interface{}{
-			"orderNumber":         parserResult.Order.OrderNumber,
-			"pickupLocation":      parserResult.OrderLocation.PickupCity + ", " + parserResult.OrderLocation.PickupState + ", " + parserResult.OrderLocation.PickupCountryName,
-			"deliveryLocation":    parserResult.OrderLocation.DeliveryCity + ", " + parserResult.OrderLocation.DeliveryState + ", " + parserResult.OrderLocation.DeliveryCountryName,
-			"pickupDate":          parserResult.Order.PickupDate,
-			"truckTypeID":         parserResult.Order.TruckTypeID,
-			"deliveryDate":        parserResult.Order.DeliveryDate,
-			"suggestedTruckSize":  parserResult.Order.SuggestedTruckSize,
-			"originalTruckSize":   parserResult.Order.OriginalTruckSize,
-			"notes":               parserResult.Order.Notes,
-			"pickupZip":           parserResult.PickupZip,
-			"deliveryZip":         parserResult.DeliveryZip,
-			"pickupCity":          parserResult.OrderLocation.PickupCity,
-			"pickupState":         parserResult.OrderLocation.PickupState,
-			"pickupStateCode":     parserResult.OrderLocation.PickupStateCode,
-			"pickupCountry":       parserResult.OrderLocation.PickupCountryCode,
-			"pickupCountryCode":   parserResult.OrderLocation.PickupCountryCode,
-			"pickupCountryName":   parserResult.OrderLocation.PickupCountryName,
-			"deliveryCountryName": parserResult.OrderLocation.DeliveryCountryName,
-			"deliveryCity":        parserResult.OrderLocation.DeliveryCity,
-			"deliveryState":       parserResult.OrderLocation.DeliveryState,
-			"deliveryStateCode":   parserResult.OrderLocation.DeliveryStateCode,
-			"deliveryCountry":     parserResult.OrderLocation.DeliveryCountryCode,
-			"deliveryCountryCode": parserResult.OrderLocation.DeliveryCountryCode,
-			"estimatedMiles":      parserResult.Order.EstimatedMiles,
-			"length":              parserResult.OrderItem.Length,
-			"width":               parserResult.OrderItem.Width,
-			"height":              parserResult.OrderItem.Height,
-			"weight":              parserResult.OrderItem.Weight,
-			"pieces":              parserResult.OrderItem.Pieces,
-			"stackable":           parserResult.OrderItem.Stackable,
-			"hazardous":           parserResult.OrderItem.Hazardous,
-			"orderTypeID":         5,
-			"replyTo":             replyTo,
-			"subject":             subject,
-			"bodyHTML":            bodyHTML,
-			"parserLogID":         parserLog.ID,
-			"bodyPlain":           bodyPlain,
-			"messageID":           messageID,
-			"createdAt":           time.Now(),
-			"updatedAt":           time.Now(),
-		}
+	// Only emails that keep their parser_log row are sampled, the samples point at it
+	recordParse(ctx, db, parserLog, outcome, email, outcome.Result)
+
+	message := NewOrderMessage(outcome, contract.Email{
+		Subject:   email.Subject,
+		MessageID: email.MessageID,
+		ReplyTo:   outcome.Result.OrderEmail.ReplyTo,
+		BodyHTML:  email.BodyHTML,
+		BodyPlain: email.BodyPlain,
+	}, parserLog.ID)
+
+	logger.WithFields(logrus.Fields{
+		"parser":            outcome.Parser,
+		"action":            message.Action,
+		"order_number":      message.OrderNumber,
+		"pickup_location":   message.Pickup.Label,
+		"delivery_location": message.Delivery.Label,
+		"pickup_date":       message.PickupDate,
+		"delivery_date":     message.DeliveryDate,
+		"truck_type_id":     message.TruckTypeID,
+		"estimated_miles":   message.EstimatedMiles,
+		"warnings":          outcome.Warnings,
+	}).Info("Parsed data from email")
+
+	metrics.IncrementMessagesParsed(outcome.Parser)
+
+	span.SetAttributes(
+		attribute.Int("parser_log.id", parserLog.ID),
+		attribute.String("order.number", message.OrderNumber),
+	)
+	if err := sendToSQS(ctx, message); err != nil {
+		logger.Error("Failed to send message to SQS: ", err)
+		tracing.End(span, err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Failed to send message"}, nil
+	}
 
-		logrus.Info("Parsed data from Landstar email:")
-		for key, value := range data {
-			if key == "bodyHTML" || key == "bodyPlain" {
-				continue
-			}
-			logrus.Infof("%s: %v", key, value)
-		}
+	logger.Info("Message successfully sent to SQS")
+	return events.APIGatewayProxyResponse{StatusCode: 200, Body: "Email data parsed and sent to SQS successfully"}, nil
+}
 
-		messageBodyBytes, err := json.Marshal(data)
-		if err != nil {
-			logrus.Error("Error marshaling data to JSON: ", err)
-			return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Failed to prepare message"}, nil
+// recordParse tracks which fields the parser filled in and samples the email's structure
+// for the drift evaluator, result is nil for a failed parse. Cancellations only carry the
+// order number, they would drag every other field down and are skipped.
+// Failures are only logged, they must not lose the email.
+func recordParse(ctx context.Context, db *gorm.DB, parserLog *models.ParserLog, outcome *ParseOutcome, email Email, result *parser.ParserResult) {
+	if outcome.Parser == "" || outcome.Action == parser.ActionCancel {
+		return
+	}
+	logger := log.FromContext(ctx)
+
+	if result != nil {
+		if err := fieldstats.Record(ctx, db, outcome.Parser, result); err != nil {
+			logger.Warn("Failed to record field fill rates: ", err)
 		}
+	}
+	if err := drift.RecordSample(ctx, db, parserLog.ID, outcome.Parser, email.BodyHTML, email.BodyPlain, result); err != nil {
+		logger.Warn("Failed to record template sample: ", err)
+	}
+}
 
-		_, err = sqsClient.SendMessage(&sqs.SendMessageInput{
-			QueueUrl:    aws.String(config.AppConfig.SQSQueueURL),
-			MessageBody: aws.String(string(messageBodyBytes)),
-		})
-		if err != nil {
-			logrus.Error("Failed to send message to SQS: ", err)
-			return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Failed to send message"}, nil
+// publishIgnored tells webhook subscribers about a load we dropped, reason is the same as the
+// messages_ignored metric label. Parse errors are not published, Mailgun retries them.
+// Failures are only logged, the email itself was handled.
+func publishIgnored(ctx context.Context, email Email, outcome *ParseOutcome, reason string) {
+	db := db.WithContext(ctx)
+	queued, err := webhook.Publish(db, webhook.EventOrderIgnored, 0, webhook.IgnoredData{
+		Parser:      outcome.Parser,
+		Subject:     email.Subject,
+		MessageID:   email.MessageID,
+		OrderNumber: parser.ExtractOrderNumberFromEmail(email.BodyHTML, email.BodyPlain),
+		Reason:      reason,
+		Detail:      outcome.Reason,
+	})
+	if err != nil {
+		log.FromContext(ctx).Error("Failed to queue order.ignored webhook: ", err)
+		return
+	}
+	for _, msg := range queued {
+		if err := outbox.Deliver(ctx, db, msg); err != nil {
+			log.FromContext(ctx).Warn("Webhook delivery failed, the outbox dispatcher will retry it: ", err)
 		}
-		logrus.Info("Landstar email data parsed and sent to SQS successfully")
-		return events.APIGatewayProxyResponse{StatusCode: 200, Body: "Landstar email data parsed, printed and sent to SQS successfully"}, nil
 	}
+}
 
-	logrus.WithFields(logrus.Fields{
-		"subject":    subject,
-		"message_id": messageID,
-		"body_plain": bodyPlain,
-		"body_html":  bodyHTML,
-	}).Info("Received email data")
+// NewOrderMessage builds the SQS message for a parsed email
+func NewOrderMessage(outcome *ParseOutcome, email contract.Email, parserLogID int) *contract.OrderMessage {
+	result := outcome.Result
+	location := result.OrderLocation
+
+	return &contract.OrderMessage{
+		Action:             string(result.Action),
+		ParserLogID:        parserLogID,
+		OrderNumber:        result.Order.OrderNumber,
+		OrderTypeID:        outcome.OrderTypeID,
+		TruckTypeID:        result.Order.TruckTypeID,
+		SuggestedTruckSize: result.Order.SuggestedTruckSize,
+		OriginalTruckSize:  result.Order.OriginalTruckSize,
+		Notes:              result.Order.Notes,
+		PickupDate:         result.Order.PickupDate,
+		DeliveryDate:       result.Order.DeliveryDate,
+		EstimatedMiles:     result.Order.EstimatedMiles,
+		Pickup: contract.Location{
+			Label:       stopLabel(outcome.Parser, result.PickupZip, location.PickupCity, location.PickupState, location.PickupCountryName),
+			Street:      location.PickupStreet,
+			City:        location.PickupCity,
+			State:       location.PickupState,
+			StateCode:   location.PickupStateCode,
+			PostalCode:  result.PickupZip,
+			CountryCode: location.PickupCountryCode,
+			CountryName: location.PickupCountryName,
+		},
+		Delivery: contract.Location{
+			Label:       stopLabel(outcome.Parser, result.DeliveryZip, location.DeliveryCity, location.DeliveryState, location.DeliveryCountryName),
+			Street:      location.DeliveryStreet,
+			City:        location.DeliveryCity,
+			State:       location.DeliveryState,
+			StateCode:   location.DeliveryStateCode,
+			PostalCode:  result.DeliveryZip,
+			CountryCode: location.DeliveryCountryCode,
+			CountryName: location.DeliveryCountryName,
+		},
+		Item: contract.Item{
+			Length:    result.OrderItem.Length,
+			Width:     result.OrderItem.Width,
+			Height:    result.OrderItem.Height,
+			Weight:    result.OrderItem.Weight,
+			Pieces:    result.OrderItem.Pieces,
+			Stackable: result.OrderItem.Stackable,
+			Hazardous: result.OrderItem.Hazardous,
+		},
+		Email:     email,
+		CreatedAt: time.Now(),
+	}
+}
 
-	var replyTo string
-	if bodyPlain != "" {
-		logrus.Info("Parsing plain text body for 'replyTo'")
-		replyTo = parser.ExtractReplyTo(bodyPlain)
-		logrus.WithField("replyTo", replyTo).Info("Extracted 'replyTo' from plain text body")
-	}
-
-	if replyTo == "" {
-		logrus.Warn("No 'replyTo' field found in the plain text body, falling back to form data")
-		replyTo = formData.Get("reply-to")
-		logrus.WithField("replyTo", replyTo).Info("Extracted 'replyTo' from form data")
-	}
-
-	var (
-		orderNumber                                                                  string
-		pickupZip, pickupCity, pickupState, pickupCountry, pickupStateCode           string
-		deliveryZip, deliveryCity, deliveryState, deliveryCountry, deliveryStateCode string
-		pickupCountryCode, deliveryCountryCode                                       string
-		pickupDateTime, deliveryDateTime                                             time.Time
-		truckSize, notes                                                             string
-		originalTruckSize                                                            string
-		length, width, height, weight                                                float64
-		pieces                                                                       int
-		stackable, hazardous                                                         bool
-		estimatedMiles                                                               int
-		truckTypeID                                                                  int
-	)
+// stopLabel formats a stop the way each parser always has.
+// Landstar loads were labelled "City, State, Country" without the zip, the platform matches on that.
+func stopLabel(parserName, zip, city, state, country string) string {
+	if parserName == "landstar" {
+		return city + ", " + state + ", " + country
+	}
+	return parser.FormatLocationLabel(zip, city, state, country)
+}
 
-	layout := "2006-01-02 15:04:05"
-
-	var htmlParsed bool
-	if bodyHTML != "" {
-		logrus.Info("Parsing HTML body")
-		doc, err := goquery.NewDocumentFromReader(strings.NewReader(bodyHTML))
-		if err != nil {
-			logrus.Error("Error parsing HTML: ", err)
-		} else {
-			orderNumber = parser.ExtractOrderNumberFromHTML(doc)
-			pickupZip, pickupCity, pickupState, pickupStateCode, pickupCountry = parser.ExtractLocationFromHTML(doc, "Pick Up")
-			deliveryZip, deliveryCity, deliveryState, deliveryStateCode, deliveryCountry = parser.ExtractLocationFromHTML(doc, "Delivery")
-			pickupDateTime, _ = time.Parse(layout, parser.FormatDateTimeString(parser.ExtractDateTimeStringFromHTML(doc, "Pick Up")))
-			deliveryDateTime, _ = time.Parse(layout, parser.FormatDateTimeString(parser.ExtractDateTimeStringFromHTML(doc, "Delivery")))
-			truckSize = parser.ExtractTruckSizeFromHTML(doc)
-			notes = parser.ExtractNotesFromHTML(doc)
-			estimatedMiles = parser.ExtractDistanceFromHTML(doc)
-			originalTruckSize = parser.ExtractTruckClassFromHTML(doc)
-			length, width, height, weight, pieces, stackable, hazardous = parser.ExtractOrderItemsFromHTML(doc)
-			pickupCountryCode = "US"
-			deliveryCountryCode = "US"
-			htmlParsed = pickupCity != "" && deliveryCity != ""
-		}
+// sendToSQS moves the email bodies to the blob store, then encodes the message and publishes it to the worker queue
+// The trace context travels in the message attributes so the worker's spans join the email's trace.
+func sendToSQS(ctx context.Context, message *contract.OrderMessage) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "sqs.SendMessage", trace.WithSpanKind(trace.SpanKindProducer))
+	defer func() { tracing.End(span, err) }()
+
+	if err := offloadBodies(ctx, message); err != nil {
+		return err
 	}
 
-	if !htmlParsed && bodyPlain != "" {
-		logrus.Warn("HTML parsing failed or incomplete, falling back to plain text body")
-		orderNumber = parser.ExtractOrderNumber(bodyPlain)
-		pickupZip, pickupCity, pickupState, pickupCountry = parser.ExtractLocation(bodyPlain, "Pick Up")
-		deliveryZip, deliveryCity, deliveryState, deliveryCountry = parser.ExtractLocation(bodyPlain, "Delivery")
-		pickupDateTime, _ = time.Parse(layout, parser.FormatDateTimeString(parser.ExtractDateTimeString(bodyPlain, "Pick Up")))
-		deliveryDateTime, _ = time.Parse(layout, parser.FormatDateTimeString(parser.ExtractDateTimeString(bodyPlain, "Delivery")))
-		truckSize = parser.ExtractTruckSize(bodyPlain)
-		notes = parser.ExtractNotes(bodyPlain)
-		length, width, height, weight, pieces, stackable, hazardous = parser.ExtractOrderItems(bodyPlain)
-		estimatedMiles = parser.ExtractDistance(bodyPlain)
-		pickupCountryCode = "US"
-		deliveryCountryCode = "US"
-	}
-
-	truckSizeMap := map[string]int{
-		"small straight":  1,
-		"large straight":  2,
-		"sprinter":        3,
-		"tractor trailer": 4,
-	}
-
-	lowerTruckSize := strings.ToLower(truckSize)
-	if id, exists := truckSizeMap[lowerTruckSize]; exists {
-		truckTypeID = id
-	} else {
-		truckTypeID = 4
-	}
-
-	data := map[string]interface{}{
-		"orderNumber":         orderNumber,
-		"pickupLocation":      pickupZip + ", " + pickupCity + ", " + pickupState + ", " + pickupCountry,
-		"deliveryLocation":    deliveryZip + ", " + deliveryCity + ", " + deliveryState + ", " + deliveryCountry,
-		"pickupDate":          pickupDateTime,
-		"deliveryDate":        deliveryDateTime,
-		"suggestedTruckSize":  truckSize,
-		"truckTypeID":         truckTypeID,
-		"originalTruckSize":   originalTruckSize,
-		"notes":               notes,
-		"pickupZip":           pickupZip,
-		"deliveryZip":         deliveryZip,
-		"pickupCity":          pickupCity,
-		"pickupState":         pickupState,
-		"pickupStateCode":     pickupStateCode,
-		"pickupCountry":       pickupCountry,
-		"pickupCountryCode":   pickupCountryCode,
-		"pickupCountryName":   pickupCountry,
-		"deliveryCountryName": deliveryCountry,
-		"deliveryCity":        deliveryCity,
-		"deliveryState":       deliveryState,
-		"deliveryStateCode":   deliveryStateCode,
-		"deliveryCountry":     deliveryCountry,
-		"deliveryCountryCode": deliveryCountryCode,
-		"estimatedMiles":      estimatedMiles,
-		"orderTypeID":         4,
-		"length":              length,
-		"width":               width,
-		"height":              height,
-		"weight":              weight,
-		"pieces":              pieces,
-		"stackable":           stackable,
-		"hazardous":           hazardous,
-		"replyTo":             replyTo,
-		"subject":             subject,
-		"bodyHTML":            bodyHTML,
-		"bodyPlain":           bodyPlain,
-		"messageID":           messageID,
-		"parserLogID":         parserLog.ID,
-		"createdAt":           time.Now(),
-		"updatedAt":           time.Now(),
-	}
-
-	messageBodyBytes, err := json.Marshal(data)
+	messageBodyBytes, err := contract.Encode(message)
 	if err != nil {
-		logrus.Error("Error marshaling data to JSON: ", err)
-		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Failed to prepare message"}, nil
+		return err
 	}
 
-	_, err = sqsClient.SendMessage(&sqs.SendMessageInput{
-		QueueUrl:    aws.String(config.AppConfig.SQSQueueURL),
-		MessageBody: aws.String(string(messageBodyBytes)),
+	output, err := sqsClient.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:          aws.String(config.AppConfig.SQSQueueURL),
+		MessageBody:       aws.String(string(messageBodyBytes)),
+		MessageAttributes: tracing.SQSAttributes(ctx),
 	})
+	if err == nil {
+		span.SetAttributes(attribute.String("messaging.message.id", aws.StringValue(output.MessageId)))
+	}
+	return err
+}
+
+// offloadBodies moves email bodies larger than BLOB_OFFLOAD_THRESHOLD to the blob store and
+// replaces them with references, keeping large HTML emails under the SQS message size limit.
+// Smaller bodies stay inline.
+func offloadBodies(ctx context.Context, message *contract.OrderMessage) error {
+	threshold := config.AppConfig.BlobOffloadThreshold
+	if len(message.Email.BodyHTML) <= threshold && len(message.Email.BodyPlain) <= threshold {
+		return nil
+	}
+
+	blobStore, err := blobstore.Default()
 	if err != nil {
-		logrus.Error("Failed to send message to SQS: ", err)
-		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Failed to send message"}, nil
+		return fmt.Errorf("failed to create blob store: %w", err)
 	}
 
-	logrus.Info("Message successfully sent to SQS")
-	return events.APIGatewayProxyResponse{StatusCode: 200, Body: "Email data parsed and sent to SQS successfully"}, nil
+	store := func(name, body string) (*contract.BlobRef, error) {
+		if len(body) <= threshold {
+			return nil, nil
+		}
+		key := fmt.Sprintf("parser-log/%d/%s", message.ParserLogID, name)
+		if err := blobStore.Put(ctx, key, []byte(body)); err != nil {
+			return nil, fmt.Errorf("failed to store %s: %w", name, err)
+		}
+		return &contract.BlobRef{
+			Key:    key,
+			SHA256: blobstore.Checksum([]byte(body)),
+			Size:   len(body),
+		}, nil
+	}
+
+	htmlRef, err := store("body.html", message.Email.BodyHTML)
+	if err != nil {
+		return err
+	}
+	plainRef, err := store("body.txt", message.Email.BodyPlain)
+	if err != nil {
+		return err
+	}
+
+	if htmlRef != nil {
+		message.Email.BodyHTMLRef = htmlRef
+		message.Email.BodyHTML = ""
+	}
+	if plainRef != nil {
+		message.Email.BodyPlainRef = plainRef
+		message.Email.BodyPlain = ""
+	}
+	return nil
 }
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/3milly4ever/parser-landstar/internal/contract"
+	"github.com/3milly4ever/parser-landstar/internal/dbtest"
+	models "github.com/3milly4ever/parser-landstar/internal/model"
+	"github.com/3milly4ever/parser-landstar/internal/parser"
+	"github.com/3milly4ever/parser-landstar/internal/routing"
+	"github.com/3milly4ever/parser-landstar/internal/webhook"
+	config "github.com/3milly4ever/parser-landstar/pkg"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestOffloadBodies(t *testing.T) {
+	config.AppConfig.BlobStore = "local"
+	config.AppConfig.BlobLocalDir = t.TempDir()
+	config.AppConfig.BlobOffloadThreshold = 16
+
+	large := strings.Repeat("x", 17)
+	tests := []struct {
+		name         string
+		html, plain  string
+		wantHTMLRef  bool
+		wantPlainRef bool
+	}{
+		{name: "small bodies stay inline", html: "<p>load</p>", plain: "load"},
+		{name: "large html is offloaded", html: large, plain: "load", wantHTMLRef: true},
+		{name: "both large", html: large, plain: large, wantHTMLRef: true, wantPlainRef: true},
+		{name: "at the threshold stays inline", html: strings.Repeat("x", 16)},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := &contract.OrderMessage{ParserLogID: i + 1, Email: contract.Email{BodyHTML: tt.html, BodyPlain: tt.plain}}
+			if err := offloadBodies(context.Background(), message); err != nil {
+				t.Fatalf("offloadBodies() error = %v", err)
+			}
+
+			check := func(name string, ref *contract.BlobRef, inline, original string, wantRef bool) {
+				switch {
+				case wantRef && (ref == nil || inline != "" || ref.Size != len(original)):
+					t.Errorf("%s: ref = %+v inline = %q, want a reference only", name, ref, inline)
+				case !wantRef && (ref != nil || inline != original):
+					t.Errorf("%s: ref = %+v inline = %q, want the body inline", name, ref, inline)
+				}
+			}
+			check("html", message.Email.BodyHTMLRef, message.Email.BodyHTML, tt.html, tt.wantHTMLRef)
+			check("plain", message.Email.BodyPlainRef, message.Email.BodyPlain, tt.plain, tt.wantPlainRef)
+		})
+	}
+}
+
+func TestNewOrderMessageLabels(t *testing.T) {
+	result := &parser.ParserResult{
+		PickupZip:   "93940",
+		DeliveryZip: "89030",
+		OrderLocation: models.OrderLocation{
+			PickupCity:          "Monterey",
+			PickupState:         "California",
+			PickupCountryName:   "United States",
+			DeliveryCity:        "North Las Vegas",
+			DeliveryState:       "Nevada",
+			DeliveryCountryName: "United States",
+		},
+	}
+
+	tests := []struct {
+		parser       string
+		wantPickup   string
+		wantDelivery string
+	}{
+		{parser: "landstar", wantPickup: "Monterey, California, United States", wantDelivery: "North Las Vegas, Nevada, United States"},
+		{parser: "fullcircle", wantPickup: "93940, Monterey, California, United States", wantDelivery: "89030, North Las Vegas, Nevada, United States"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.parser, func(t *testing.T) {
+			message := NewOrderMessage(&ParseOutcome{Parser: tt.parser, OrderTypeID: 5, Result: result}, contract.Email{}, 1)
+			if message.Pickup.Label != tt.wantPickup || message.Delivery.Label != tt.wantDelivery {
+				t.Errorf("labels = %q / %q, want %q / %q", message.Pickup.Label, message.Delivery.Label, tt.wantPickup, tt.wantDelivery)
+			}
+			if message.OrderTypeID != 5 {
+				t.Errorf("OrderTypeID = %d, want 5", message.OrderTypeID)
+			}
+		})
+	}
+}
+
+func TestIgnoredEmailsArePublished(t *testing.T) {
+	config.AppConfig.DefaultRoute = routing.QuarantineRoute
+	initRoutes.Do(func() {})
+	routeTable, routeTableErr = routing.Load()
+	if routeTableErr != nil {
+		t.Fatalf("routing.Load() error = %v", routeTableErr)
+	}
+
+	landstar := `<a href="https://www.LandstarCarriers.com/Loads">Loads</a>`
+	tests := []struct {
+		name       string
+		subject    string
+		bodyHTML   string
+		bodyPlain  string
+		wantReason string
+	}{
+		{name: "no route", subject: "Hello", bodyPlain: "Nothing to see here", wantReason: "quarantined"},
+		{name: "cancellation without order number", subject: "Load cancelled", bodyHTML: landstar, wantReason: "cancel_without_order_number"},
+		{
+			name:       "ignored truck size",
+			subject:    "FLATBED from MONTEREY, CA",
+			bodyHTML:   landstar + `<table><tr><td>Load #: 82163</td></tr><tr><td>Trailer Type: FLATBED</td></tr></table>`,
+			wantReason: "truck_size",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testDB, conn := dbtest.Open(t)
+			conn.AddRows("`webhook_subscription` WHERE active", []string{"id", "url", "events", "active"},
+				[]driver.Value{int64(1), "https://hooks.example.com/parser", webhook.EventOrderIgnored, true})
+			SetDB(testDB)
+			defer SetDB(nil)
+
+			form := url.Values{"subject": {tt.subject}, "body-html": {tt.bodyHTML}, "body-plain": {tt.bodyPlain}, "Message-Id": {"<abc@example.com>"}}
+			response, err := LambdaHandler(context.Background(), events.APIGatewayProxyRequest{Body: form.Encode()})
+			if err != nil || response.StatusCode != 200 {
+				t.Fatalf("LambdaHandler() = %d %q, %v", response.StatusCode, response.Body, err)
+			}
+
+			queued := conn.Executed("INSERT INTO `outbox`")
+			if len(queued) != 1 {
+				t.Fatalf("queued %d outbox messages, want 1", len(queued))
+			}
+			if payload := fmt.Sprint(queued[0].Args); !strings.Contains(payload, `"reason":"`+tt.wantReason+`"`) {
+				t.Errorf("payload %s, want reason %s", payload, tt.wantReason)
+			}
+
+			// Ignored emails must not leave samples behind, the truck size one loses its parser_log row
+			for _, table := range []string{"parser_template_sample", "parser_field_stats"} {
+				if rows := conn.Executed("INSERT INTO `" + table + "`"); len(rows) != 0 {
+					t.Errorf("wrote %d %s rows, want none", len(rows), table)
+				}
+			}
+			if deleted := len(conn.Executed("DELETE FROM `parser_log`")) > 0; deleted != (tt.wantReason == "truck_size") {
+				t.Errorf("parser_log deleted = %v", deleted)
+			}
+			if updated := len(conn.Executed("UPDATE `parser_log`")) > 0; updated != (tt.wantReason == "cancel_without_order_number") {
+				t.Errorf("parser_log updated = %v", updated)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/3milly4ever/parser-landstar/internal/contract"
+	"github.com/3milly4ever/parser-landstar/internal/log"
+	"github.com/3milly4ever/parser-landstar/internal/parser"
+	"github.com/3milly4ever/parser-landstar/internal/routing"
+	"github.com/sirupsen/logrus"
+)
+
+// ParseOutcome is what routing, detection and parsing decided about an email.
+// Building it has no side effects, which lets the preview endpoint reuse it.
+type ParseOutcome struct {
+	Route       string               `json:"route"`
+	Parser      string               `json:"parser"`
+	ParserID    uint64               `json:"parser_id"`
+	OrderTypeID int                  `json:"order_type_id"`
+	Quarantined bool                 `json:"quarantined"`
+	Action      parser.EmailAction   `json:"action"`
+	Result      *parser.ParserResult `json:"result"`
+	Accepted    bool                 `json:"accepted"`
+	Reason      string               `json:"reason,omitempty"`
+	Warnings    []string             `json:"warnings"`
+}
+
+var (
+	routeTable    *routing.Table
+	routeTableErr error
+	initRoutes    sync.Once
+)
+
+// RouteEmail finds the route for an email in the routing table, nil means quarantine
+func RouteEmail(email Email) (*routing.Route, error) {
+	initRoutes.Do(func() {
+		routeTable, routeTableErr = routing.Load()
+	})
+	if routeTableErr != nil {
+		return nil, fmt.Errorf("failed to load routing table: %w", routeTableErr)
+	}
+
+	return routeTable.Match(routing.Envelope{
+		Recipient: email.Recipient,
+		From:      email.From,
+		Subject:   email.Subject,
+		BodyHTML:  email.BodyHTML,
+		BodyPlain: email.BodyPlain,
+	}), nil
+}
+
+// ParseEmail routes the email, classifies it and runs the chosen parser.
+// It never writes to MySQL or publishes to SQS, geocoding only reads the geocode_cache table.
+func ParseEmail(ctx context.Context, email Email) (*ParseOutcome, error) {
+	logger := log.FromContext(ctx)
+	outcome := &ParseOutcome{
+		Action:   parser.ClassifyEmail(email.Subject, email.BodyHTML, email.BodyPlain),
+		Warnings: []string{},
+	}
+	logger.WithField("action", outcome.Action).Info("Classified email")
+
+	route, err := RouteEmail(email)
+	if err != nil {
+		return outcome, err
+	}
+	if route == nil {
+		outcome.Quarantined = true
+		outcome.Reason = "no route matches this email, quarantined"
+		return outcome, nil
+	}
+
+	outcome.Route = route.Name
+	outcome.Parser = route.Parser
+	outcome.ParserID = route.ParserID
+	outcome.OrderTypeID = route.OrderTypeID
+	emailParser, _ := parser.ByName(route.Parser)
+	logger.WithFields(logrus.Fields{
+		"route":  route.Name,
+		"parser": route.Parser,
+	}).Info("Routed email")
+
+	// Cancellations only need the order number they refer to
+	if outcome.Action == parser.ActionCancel {
+		orderNumber := parser.ExtractOrderNumberFromEmail(email.BodyHTML, email.BodyPlain)
+		if orderNumber == "" {
+			outcome.Reason = "cancellation email without an order number"
+			return outcome, nil
+		}
+		outcome.Result = &parser.ParserResult{Action: parser.ActionCancel}
+		outcome.Result.Order.OrderNumber = orderNumber
+		outcome.Accepted = true
+		return outcome, nil
+	}
+
+	result, err := emailParser.Parse(ctx, email.BodyHTML, email.BodyPlain)
+	if err != nil {
+		return outcome, fmt.Errorf("%s parser failed: %w", outcome.Parser, err)
+	}
+	if result == nil {
+		outcome.Reason = "load ignored due to truck size"
+		return outcome, nil
+	}
+
+	result.Action = outcome.Action
+	if result.OrderEmail.ReplyTo == "" && email.BodyPlain != "" {
+		result.OrderEmail.ReplyTo = parser.ExtractReplyTo(ctx, email.BodyPlain)
+	}
+	if result.OrderEmail.ReplyTo == "" {
+		result.OrderEmail.ReplyTo = email.ReplyTo
+	}
+
+	outcome.Result = result
+	outcome.Accepted = true
+	outcome.Warnings = resultWarnings(result)
+	return outcome, nil
+}
+
+// resultWarnings lists the fields the worker needs but the parser could not find
+func resultWarnings(result *parser.ParserResult) []string {
+	warnings := []string{}
+	check := func(missing bool, warning string) {
+		if missing {
+			warnings = append(warnings, warning)
+		}
+	}
+
+	check(result.Order.OrderNumber == "", "order number not found")
+	check(result.OrderLocation.PickupCity == "", "pickup city not found, the worker will skip this message")
+	check(result.OrderLocation.DeliveryCity == "", "delivery city not found, the worker will skip this message")
+	check(result.PickupZip == "", "pickup zip not found, pickup will not be geocoded")
+	check(result.DeliveryZip == "", "delivery zip not found, delivery will not be geocoded")
+	check(result.Order.PickupDate.IsZero(), "pickup date not found")
+	check(result.Order.DeliveryDate.IsZero(), "delivery date not found")
+	check(result.OrderItem.Length == 0, "length not found")
+	check(result.OrderItem.Weight == 0, "weight not found")
+	check(result.Order.EstimatedMiles == 0, "miles not found")
+	check(result.OrderEmail.ReplyTo == "", "reply-to address not found")
+
+	return warnings
+}
+
+// PreviewResponse is returned by the parse preview endpoint
+type PreviewResponse struct {
+	Email Email `json:"email"`
+	*ParseOutcome
+	Message *contract.OrderMessage `json:"message,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// Preview runs detection and parsing and shows the SQS message that would be sent,
+// without writing to MySQL or publishing to SQS
+func Preview(ctx context.Context, email Email) PreviewResponse {
+	response := PreviewResponse{Email: email}
+
+	outcome, err := ParseEmail(ctx, email)
+	response.ParseOutcome = outcome
+	if err != nil {
+		response.Error = err.Error()
+		return response
+	}
+
+	if outcome.Accepted {
+		response.Message = NewOrderMessage(outcome, contract.Email{
+			Subject:   email.Subject,
+			MessageID: email.MessageID,
+			ReplyTo:   outcome.Result.OrderEmail.ReplyTo,
+		}, 0)
+		response.Message.SchemaVersion = contract.SchemaVersion
+	}
+	return response
+}
@@ -0,0 +1,95 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"gorm.io/gorm"
+)
+
+// MySQL pings the database. open is called on every probe and must retry a connection
+// that failed, like handler.InitializeDB does, so a database that was unavailable at
+// startup is picked up once it comes back.
+func MySQL(open func() (*gorm.DB, error)) Check {
+	return Check{Name: "mysql", Probe: func(ctx context.Context) error {
+		db, err := open()
+		if err != nil {
+			return err
+		}
+		if db == nil {
+			return fmt.Errorf("database is not initialized")
+		}
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	}}
+}
+
+var (
+	sqsClient     *sqs.SQS
+	sqsClientErr  error
+	sqsClientOnce sync.Once
+)
+
+// SQSQueue reads an attribute of the worker queue, which needs both network access and permissions
+func SQSQueue(queueURL string) Check {
+	return Check{Name: "sqs", Probe: func(ctx context.Context) error {
+		if queueURL == "" {
+			return ErrSkipped
+		}
+		sqsClientOnce.Do(func() {
+			var sess *session.Session
+			sess, sqsClientErr = session.NewSession()
+			if sqsClientErr == nil {
+				sqsClient = sqs.New(sess)
+			}
+		})
+		if sqsClientErr != nil {
+			return sqsClientErr
+		}
+
+		_, err := sqsClient.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+			QueueUrl:       aws.String(queueURL),
+			AttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameApproximateNumberOfMessages}),
+		})
+		return err
+	}}
+}
+
+var httpClient = &http.Client{
+	// Report the redirect instead of following it, reaching the host is enough
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// HTTP checks that a service answers at url. Any response below 500 counts as reachable,
+// the probe does not know the service's API well enough to expect a particular status.
+// A HEAD request is used so nothing is triggered on the other side.
+func HTTP(name, url string) Check {
+	return Check{Name: name, Probe: func(ctx context.Context) error {
+		if url == "" {
+			return ErrSkipped
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("%s returned status %d", name, resp.StatusCode)
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,103 @@
+// Package health answers the liveness and readiness probes of load balancers and containers.
+//
+// Liveness only says the process is serving requests. Readiness runs every dependency
+// check concurrently, each under its own timeout, and is ready only when none of them failed.
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Check statuses
+const (
+	StatusOK      = "ok"
+	StatusFail    = "fail"
+	StatusSkipped = "skipped"
+)
+
+// ErrSkipped is returned by a check whose dependency is not configured, it does not fail readiness
+var ErrSkipped = errors.New("not configured")
+
+// Check probes one dependency
+type Check struct {
+	Name  string
+	Probe func(ctx context.Context) error
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the readiness response
+type Report struct {
+	Status    string    `json:"status"`
+	CheckedAt time.Time `json:"checked_at"`
+	Checks    []Result  `json:"checks"`
+}
+
+// Ready reports whether every check passed or was skipped
+func (r Report) Ready() bool {
+	return r.Status == StatusOK
+}
+
+// Run runs the checks concurrently, giving each at most timeout
+func Run(ctx context.Context, timeout time.Duration, checks ...Check) Report {
+	results := make([]Result, len(checks))
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = run(ctx, timeout, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, CheckedAt: time.Now(), Checks: results}
+	for _, result := range results {
+		if result.Status == StatusFail {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+func run(ctx context.Context, timeout time.Duration, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// The probe runs in its own goroutine so a client that ignores ctx cannot hold up the report
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() { done <- check.Probe(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := Result{
+		Name:      check.Name,
+		Status:    StatusOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	switch {
+	case errors.Is(err, ErrSkipped):
+		result.Status = StatusSkipped
+		result.Error = err.Error()
+	case err != nil:
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,53 @@
+package log
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Correlation fields, every log line about an email carries the ones known at that point
+const (
+	FieldParserLogID  = "parser_log_id"
+	FieldMessageID    = "message_id"
+	FieldOrderID      = "order_id"
+	FieldSQSMessageID = "sqs_message_id"
+)
+
+type loggerKey struct{}
+
+// WithFields returns a copy of ctx whose logger also carries fields
+func WithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	return context.WithValue(ctx, loggerKey{}, entry(ctx).WithFields(fields))
+}
+
+// WithField returns a copy of ctx whose logger also carries key
+func WithField(ctx context.Context, key string, value interface{}) context.Context {
+	return WithFields(ctx, logrus.Fields{key: value})
+}
+
+// FromContext returns the request scoped logger of ctx, with the IDs of the current trace
+// so a log line can be found from its span and the other way around
+func FromContext(ctx context.Context) *logrus.Entry {
+	logger := entry(ctx)
+	if ctx == nil {
+		return logger
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		logger = logger.WithFields(logrus.Fields{
+			"trace_id": span.TraceID().String(),
+			"span_id":  span.SpanID().String(),
+		})
+	}
+	return logger.WithContext(ctx)
+}
+
+func entry(ctx context.Context) *logrus.Entry {
+	if ctx != nil {
+		if logger, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+			return logger
+		}
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
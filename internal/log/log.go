@@ -4,7 +4,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
+	config "github.com/3milly4ever/parser-landstar/pkg"
 	"github.com/sirupsen/logrus"
 )
 
@@ -30,11 +32,33 @@ func InitLogger() {
 		logrus.SetOutput(multiWriter)
 	}
 
-	// Set the log level (optional, default is Info)
-	logrus.SetLevel(logrus.InfoLevel)
+	Configure()
+}
+
+// Configure sets the log level and format from LOG_LEVEL and LOG_FORMAT and installs the redaction hook.
+// The Lambdas log to stdout only, so they call it instead of InitLogger.
+func Configure() {
+	level, err := logrus.ParseLevel(config.AppConfig.LogLevel)
+	if err != nil {
+		logrus.Warnf("Invalid log level %q, using info", config.AppConfig.LogLevel)
+		level = logrus.InfoLevel
+	}
+	logrus.SetLevel(level)
+
+	// Mask PII and secrets in everything logged from here on
+	hooks := make(logrus.LevelHooks)
+	hooks.Add(NewRedactHook())
+	logrus.StandardLogger().ReplaceHooks(hooks)
 
-	// Set log format (optional)
-	logrus.SetFormatter(&logrus.TextFormatter{
-		FullTimestamp: true,
-	})
+	switch config.AppConfig.LogFormat {
+	case "json":
+		// One object per line, CloudWatch Logs Insights can query the fields directly
+		logrus.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: time.RFC3339Nano,
+		})
+	default:
+		logrus.SetFormatter(&logrus.TextFormatter{
+			FullTimestamp: true,
+		})
+	}
 }
@@ -0,0 +1,117 @@
+package log
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	config "github.com/3milly4ever/parser-landstar/pkg"
+	"github.com/sirupsen/logrus"
+)
+
+// Fields holding a whole email or SQS message, only logged in full at debug level with LOG_FULL_BODIES
+var bodyFields = map[string]bool{
+	"body_html":   true,
+	"body_plain":  true,
+	"raw_message": true,
+	"body":        true,
+}
+
+// Fields whose name says they hold a credential are never logged
+var secretFieldRegex = regexp.MustCompile(`(?i)(password|passwd|secret|token|authorization|api_?key|access_?key|dsn)`)
+
+var (
+	emailRegex = regexp.MustCompile(`([A-Za-z0-9._%+-])[A-Za-z0-9._%+-]*@([A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+	// A separator is required so order numbers and other bare digit runs are left alone
+	phoneRegex = regexp.MustCompile(`(?:\+?1[-. ]?)?\(?\b\d{3}\)?[-. ]\d{3}[-. ](\d{4})\b`)
+	// The password of a MySQL DSN runs up to the last @ before the address, it may contain @ and /
+	dsnCredentialsRegex = regexp.MustCompile(`([A-Za-z0-9_.-]+):\S*@((?:tcp|unix)\(|/)`)
+	// The password of a URL runs up to the last @ before the path
+	urlCredentialsRegex = regexp.MustCompile(`([a-z][a-z0-9+.-]*://[^:@/\s]+):[^/\s]*@`)
+	// Any other user:password@
+	credentialsRegex = regexp.MustCompile(`([A-Za-z0-9_.-]+):[^@/\s]+@`)
+)
+
+// RedactHook masks email addresses, phone numbers and credentials in every log entry
+// and truncates email bodies to LOG_BODY_LIMIT characters. Bodies are only kept whole
+// when LOG_FULL_BODIES is set and the entry is logged at debug level.
+type RedactHook struct {
+	FullBodies bool
+	BodyLimit  int
+}
+
+// NewRedactHook creates the hook from the configuration
+func NewRedactHook() *RedactHook {
+	return &RedactHook{
+		FullBodies: config.AppConfig.LogFullBodies,
+		BodyLimit:  config.AppConfig.LogBodyLimit,
+	}
+}
+
+func (h *RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *RedactHook) Fire(entry *logrus.Entry) error {
+	fullBodies := h.FullBodies && entry.Level >= logrus.DebugLevel
+	entry.Message = h.redactBody(Redact(entry.Message), fullBodies)
+
+	for key, value := range entry.Data {
+		// Message IDs look like email addresses, masking them would break correlation
+		if key == FieldMessageID || key == FieldSQSMessageID {
+			continue
+		}
+		if secretFieldRegex.MatchString(key) {
+			entry.Data[key] = "[REDACTED]"
+			continue
+		}
+
+		var text string
+		switch v := value.(type) {
+		case string:
+			text = v
+		case error:
+			text = v.Error()
+		case fmt.Stringer:
+			text = v.String()
+		default:
+			continue
+		}
+
+		if bodyFields[key] {
+			if !fullBodies {
+				text = truncate(text, h.BodyLimit)
+			}
+		} else {
+			text = h.redactBody(text, fullBodies)
+		}
+		entry.Data[key] = Redact(text)
+	}
+	return nil
+}
+
+// redactBody truncates anything as long as an email body, wherever it was logged
+func (h *RedactHook) redactBody(text string, fullBodies bool) string {
+	if fullBodies || h.BodyLimit <= 0 || len(text) <= 4*h.BodyLimit {
+		return text
+	}
+	return truncate(text, h.BodyLimit)
+}
+
+// Redact masks email addresses, phone numbers and credentials in text,
+// keeping enough of each to tell them apart: j***@example.com, ***-***-1234, user:***@
+func Redact(text string) string {
+	text = dsnCredentialsRegex.ReplaceAllString(text, "$1:***@$2")
+	text = urlCredentialsRegex.ReplaceAllString(text, "$1:***@")
+	text = credentialsRegex.ReplaceAllString(text, "$1:***@")
+	text = emailRegex.ReplaceAllString(text, "$1***@$2")
+	text = phoneRegex.ReplaceAllString(text, "***-***-$1")
+	return text
+}
+
+func truncate(text string, limit int) string {
+	if limit <= 0 || len(text) <= limit {
+		return text
+	}
+	return fmt.Sprintf("%s... (%d more bytes)", strings.ToValidUTF8(text[:limit], ""), len(text)-limit)
+}
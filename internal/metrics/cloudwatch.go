@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/sirupsen/logrus"
+)
+
+// cloudWatchBatchSize is how many datums go into one PutMetricData call
+const cloudWatchBatchSize = 500
+
+// CloudWatch aggregates metrics in memory and publishes them with PutMetricData on Flush
+// and every flush interval, instead of making one API call per event.
+// Counters are summed and observations are sent as statistic sets.
+// Labelled metrics are also published without dimensions, the series the existing
+// dashboards and alarms read.
+type CloudWatch struct {
+	namespace string
+
+	clientOnce sync.Once
+	client     *cloudwatch.CloudWatch
+
+	mu      sync.Mutex
+	pending map[string]*cloudWatchSeries
+}
+
+type cloudWatchSeries struct {
+	name       string
+	unit       string
+	dimensions []*cloudwatch.Dimension
+	counter    bool
+	count      float64
+	sum        float64
+	min        float64
+	max        float64
+}
+
+// NewCloudWatch creates the recorder, the AWS session is only created on the first flush.
+// A positive interval also flushes in the background.
+func NewCloudWatch(namespace string, interval time.Duration) *CloudWatch {
+	c := &CloudWatch{
+		namespace: namespace,
+		pending:   map[string]*cloudWatchSeries{},
+	}
+	if interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				c.Flush()
+			}
+		}()
+	}
+	return c
+}
+
+func (c *CloudWatch) Count(name string, value float64, labels Labels) {
+	c.add(name, value, labels, true)
+}
+
+func (c *CloudWatch) Observe(name string, value float64, labels Labels) {
+	c.add(name, value, labels, false)
+}
+
+func (c *CloudWatch) add(name string, value float64, labels Labels, counter bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.addSeries(name, value, labels, counter)
+	if len(labels) > 0 {
+		c.addSeries(name, value, nil, counter)
+	}
+}
+
+func (c *CloudWatch) addSeries(name string, value float64, labels Labels, counter bool) {
+	key := seriesKey(name, labels)
+	series, ok := c.pending[key]
+	if !ok {
+		metricName, unit := cloudWatchName(name, counter)
+		series = &cloudWatchSeries{
+			name:       metricName,
+			unit:       unit,
+			dimensions: cloudWatchDimensions(labels),
+			counter:    counter,
+			min:        math.Inf(1),
+			max:        math.Inf(-1),
+		}
+		c.pending[key] = series
+	}
+	series.count++
+	series.sum += value
+	series.min = math.Min(series.min, value)
+	series.max = math.Max(series.max, value)
+}
+
+func (c *CloudWatch) Flush() {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = map[string]*cloudWatchSeries{}
+	c.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	now := time.Now()
+	datums := make([]*cloudwatch.MetricDatum, 0, len(pending))
+	for _, series := range pending {
+		datum := &cloudwatch.MetricDatum{
+			MetricName: aws.String(series.name),
+			Unit:       aws.String(series.unit),
+			Dimensions: series.dimensions,
+			Timestamp:  aws.Time(now),
+		}
+		if series.counter {
+			datum.Value = aws.Float64(series.sum)
+		} else {
+			datum.StatisticValues = &cloudwatch.StatisticSet{
+				SampleCount: aws.Float64(series.count),
+				Sum:         aws.Float64(series.sum),
+				Minimum:     aws.Float64(series.min),
+				Maximum:     aws.Float64(series.max),
+			}
+		}
+		datums = append(datums, datum)
+	}
+
+	c.clientOnce.Do(func() {
+		sess, err := session.NewSession()
+		if err != nil {
+			logrus.Error("Failed to create AWS session for CloudWatch metrics: ", err)
+			return
+		}
+		c.client = cloudwatch.New(sess)
+	})
+	if c.client == nil {
+		return
+	}
+
+	for start := 0; start < len(datums); start += cloudWatchBatchSize {
+		end := start + cloudWatchBatchSize
+		if end > len(datums) {
+			end = len(datums)
+		}
+		_, err := c.client.PutMetricData(&cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(c.namespace),
+			MetricData: datums[start:end],
+		})
+		if err != nil {
+			logrus.WithError(err).Error("Failed to publish CloudWatch metrics")
+		}
+	}
+}
+
+// cloudWatchName turns "processing_duration_seconds" into "ProcessingDuration" with unit Seconds,
+// keeping the metric names the dashboards already use
+func cloudWatchName(name string, counter bool) (string, string) {
+	unit := "None"
+	if counter {
+		unit = "Count"
+	}
+	if strings.HasSuffix(name, "_seconds") {
+		name = strings.TrimSuffix(name, "_seconds")
+		unit = "Seconds"
+	}
+
+	var b strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String(), unit
+}
+
+func cloudWatchDimensions(labels Labels) []*cloudwatch.Dimension {
+	dimensions := make([]*cloudwatch.Dimension, 0, len(labels))
+	for _, name := range labelNames(labels) {
+		dimensions = append(dimensions, &cloudwatch.Dimension{
+			Name:  aws.String(name),
+			Value: aws.String(labels[name]),
+		})
+	}
+	return dimensions
+}
@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EMF limits: metrics per document and values per metric
+const (
+	emfMaxMetrics = 100
+	emfMaxValues  = 100
+)
+
+// EMF buffers metrics and writes them on Flush as CloudWatch Embedded Metric Format log lines.
+// CloudWatch Logs extracts the metrics from the Lambda output, so recording costs no API calls.
+// Every label set becomes one document whose labels are its dimensions. Labelled documents
+// also declare an empty dimension set, so the dimensionless series the existing dashboards
+// and alarms read keep receiving data.
+type EMF struct {
+	namespace string
+	out       io.Writer
+
+	mu     sync.Mutex
+	groups map[string]*emfGroup
+}
+
+type emfGroup struct {
+	labels  Labels
+	metrics map[string]*emfMetric
+	order   []string
+}
+
+type emfMetric struct {
+	unit    string
+	counter bool
+	sum     float64
+	values  []float64
+}
+
+// NewEMF creates an emitter that writes to out, normally os.Stdout.
+// A positive interval also flushes in the background for long running processes.
+func NewEMF(namespace string, out io.Writer, interval time.Duration) *EMF {
+	if out == nil {
+		out = os.Stdout
+	}
+	e := &EMF{
+		namespace: namespace,
+		out:       out,
+		groups:    map[string]*emfGroup{},
+	}
+	if interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				e.Flush()
+			}
+		}()
+	}
+	return e
+}
+
+func (e *EMF) Count(name string, value float64, labels Labels) {
+	e.add(name, value, labels, true)
+}
+
+func (e *EMF) Observe(name string, value float64, labels Labels) {
+	e.add(name, value, labels, false)
+}
+
+func (e *EMF) add(name string, value float64, labels Labels, counter bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	groupKey := seriesKey("", labels)
+	group, ok := e.groups[groupKey]
+	if !ok {
+		group = &emfGroup{labels: labels, metrics: map[string]*emfMetric{}}
+		e.groups[groupKey] = group
+	}
+
+	metricName, unit := cloudWatchName(name, counter)
+	metric, ok := group.metrics[metricName]
+	if !ok {
+		metric = &emfMetric{unit: unit, counter: counter}
+		group.metrics[metricName] = metric
+		group.order = append(group.order, metricName)
+	}
+	if counter {
+		metric.sum += value
+	} else {
+		metric.values = append(metric.values, value)
+	}
+}
+
+// Flush writes one line per label set and clears the buffer
+func (e *EMF) Flush() {
+	e.mu.Lock()
+	groups := e.groups
+	e.groups = map[string]*emfGroup{}
+	e.mu.Unlock()
+
+	timestamp := time.Now().UnixMilli()
+	for _, group := range groups {
+		for _, document := range group.documents(e.namespace, timestamp) {
+			line, err := json.Marshal(document)
+			if err != nil {
+				logrus.Error("Failed to encode EMF metrics: ", err)
+				continue
+			}
+			line = append(line, '\n')
+			if _, err := e.out.Write(line); err != nil {
+				logrus.Error("Failed to write EMF metrics: ", err)
+			}
+		}
+	}
+}
+
+type emfDirective struct {
+	Namespace  string          `json:"Namespace"`
+	Dimensions [][]string      `json:"Dimensions"`
+	Metrics    []emfDefinition `json:"Metrics"`
+}
+
+type emfDefinition struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+// documents splits a group into EMF documents that respect the per document limits
+func (g *emfGroup) documents(namespace string, timestamp int64) []map[string]interface{} {
+	dimensionSets := [][]string{labelNames(g.labels)}
+	if len(g.labels) > 0 {
+		dimensionSets = append(dimensionSets, []string{})
+	}
+
+	var documents []map[string]interface{}
+	var document map[string]interface{}
+	var directive *emfDirective
+	start := func() {
+		directive = &emfDirective{Namespace: namespace, Dimensions: dimensionSets}
+		document = map[string]interface{}{
+			"_aws": map[string]interface{}{
+				"Timestamp":         timestamp,
+				"CloudWatchMetrics": []*emfDirective{directive},
+			},
+		}
+		for name, value := range g.labels {
+			document[name] = value
+		}
+		documents = append(documents, document)
+	}
+
+	for _, name := range g.order {
+		metric := g.metrics[name]
+
+		// A metric with more values than a document allows is spread over several documents
+		chunks := [][]float64{nil}
+		if !metric.counter {
+			chunks = nil
+			for i := 0; i < len(metric.values); i += emfMaxValues {
+				end := i + emfMaxValues
+				if end > len(metric.values) {
+					end = len(metric.values)
+				}
+				chunks = append(chunks, metric.values[i:end])
+			}
+		}
+
+		for _, chunk := range chunks {
+			if document == nil || len(directive.Metrics) == emfMaxMetrics || document[name] != nil {
+				start()
+			}
+			directive.Metrics = append(directive.Metrics, emfDefinition{Name: name, Unit: metric.unit})
+			if metric.counter {
+				document[name] = metric.sum
+			} else {
+				document[name] = chunk
+			}
+		}
+	}
+	return documents
+}
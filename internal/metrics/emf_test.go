@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// emfDocument is the part of an EMF line the tests look at
+type emfDocument struct {
+	AWS struct {
+		Timestamp         int64 `json:"Timestamp"`
+		CloudWatchMetrics []struct {
+			Namespace  string          `json:"Namespace"`
+			Dimensions [][]string      `json:"Dimensions"`
+			Metrics    []emfDefinition `json:"Metrics"`
+		} `json:"CloudWatchMetrics"`
+	} `json:"_aws"`
+}
+
+func flushEMF(t *testing.T, record func(e *EMF)) []map[string]json.RawMessage {
+	t.Helper()
+	var out bytes.Buffer
+	e := NewEMF("Test", &out, 0)
+	record(e)
+	e.Flush()
+
+	var documents []map[string]json.RawMessage
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var document map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(line), &document); err != nil {
+			t.Fatalf("invalid EMF line %q: %v", line, err)
+		}
+		documents = append(documents, document)
+	}
+	return documents
+}
+
+func TestEMFDocumentShape(t *testing.T) {
+	tests := []struct {
+		name           string
+		labels         Labels
+		wantDimensions [][]string
+	}{
+		{name: "without labels", wantDimensions: [][]string{{}}},
+		{name: "with labels", labels: Labels{"reason": "timeout", "parser": "landstar"}, wantDimensions: [][]string{{"parser", "reason"}, {}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			documents := flushEMF(t, func(e *EMF) {
+				e.Count("messages_failed", 1, tt.labels)
+				e.Count("messages_failed", 2, tt.labels)
+				e.Observe("processing_duration_seconds", 0.5, tt.labels)
+			})
+			if len(documents) != 1 {
+				t.Fatalf("documents = %d, want 1", len(documents))
+			}
+			document := documents[0]
+
+			var meta emfDocument
+			raw, _ := json.Marshal(document)
+			if err := json.Unmarshal(raw, &meta); err != nil {
+				t.Fatal(err)
+			}
+			if meta.AWS.Timestamp == 0 || len(meta.AWS.CloudWatchMetrics) != 1 {
+				t.Fatalf("_aws = %+v", meta.AWS)
+			}
+			directive := meta.AWS.CloudWatchMetrics[0]
+			if directive.Namespace != "Test" {
+				t.Errorf("Namespace = %s, want Test", directive.Namespace)
+			}
+			if !reflect.DeepEqual(directive.Dimensions, tt.wantDimensions) {
+				t.Errorf("Dimensions = %v, want %v", directive.Dimensions, tt.wantDimensions)
+			}
+			wantMetrics := []emfDefinition{{Name: "MessagesFailed", Unit: "Count"}, {Name: "ProcessingDuration", Unit: "Seconds"}}
+			if !reflect.DeepEqual(directive.Metrics, wantMetrics) {
+				t.Errorf("Metrics = %v, want %v", directive.Metrics, wantMetrics)
+			}
+
+			if got := string(document["MessagesFailed"]); got != "3" {
+				t.Errorf("MessagesFailed = %s, want 3", got)
+			}
+			if got := string(document["ProcessingDuration"]); got != "[0.5]" {
+				t.Errorf("ProcessingDuration = %s, want [0.5]", got)
+			}
+			for name, value := range tt.labels {
+				if got := string(document[name]); got != `"`+value+`"` {
+					t.Errorf("%s = %s, want %q", name, got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestEMFSplitsLargeDocuments(t *testing.T) {
+	documents := flushEMF(t, func(e *EMF) {
+		for i := 0; i < emfMaxValues+1; i++ {
+			e.Observe("processing_duration_seconds", float64(i), nil)
+		}
+	})
+	if len(documents) != 2 {
+		t.Fatalf("documents = %d, want 2", len(documents))
+	}
+
+	var first, second []float64
+	json.Unmarshal(documents[0]["ProcessingDuration"], &first)
+	json.Unmarshal(documents[1]["ProcessingDuration"], &second)
+	if len(first) != emfMaxValues || len(second) != 1 {
+		t.Errorf("values per document = %d and %d, want %d and 1", len(first), len(second), emfMaxValues)
+	}
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+)
+
+// Memory keeps every metric in memory so tests can assert on what was recorded
+type Memory struct {
+	mu           sync.Mutex
+	counts       map[string]float64
+	observations map[string][]float64
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		counts:       map[string]float64{},
+		observations: map[string][]float64{},
+	}
+}
+
+func (m *Memory) Count(name string, value float64, labels Labels) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[seriesKey(name, labels)] += value
+}
+
+func (m *Memory) Observe(name string, value float64, labels Labels) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := seriesKey(name, labels)
+	m.observations[key] = append(m.observations[key], value)
+}
+
+func (m *Memory) Flush() {}
+
+// Counter returns the total counted for a metric and label set
+func (m *Memory) Counter(name string, labels Labels) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counts[seriesKey(name, labels)]
+}
+
+// Observations returns the values observed for a metric and label set
+func (m *Memory) Observations(name string, labels Labels) []float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]float64(nil), m.observations[seriesKey(name, labels)]...)
+}
+
+// Reset forgets everything recorded so far
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts = map[string]float64{}
+	m.observations = map[string][]float64{}
+}
+
+// seriesKey identifies a metric and label set, e.g. messages_failed{reason="timeout"}
+func seriesKey(name string, labels Labels) string {
+	if len(labels) == 0 {
+		return name
+	}
+	pairs := make([]string, 0, len(labels))
+	for _, label := range labelNames(labels) {
+		pairs = append(pairs, label+`="`+labels[label]+`"`)
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
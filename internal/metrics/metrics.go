@@ -1,81 +1,173 @@
+// Package metrics records counters and latencies through a pluggable Recorder.
+//
+// The backends are chosen with METRICS_BACKEND, a comma separated list of
+// emf, cloudwatch, prometheus, memory and noop. Nothing talks to AWS until the
+// CloudWatch backend is actually selected, so importing this package is free.
 package metrics
 
 import (
-	"time"
+	"os"
+	"strings"
+	"sync"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	config "github.com/3milly4ever/parser-landstar/pkg"
 	"github.com/sirupsen/logrus"
 )
 
+// Labels are the dimensions of a metric, such as the parser name or the failure reason.
+// A metric must always be recorded with the same label names.
+type Labels map[string]string
+
+// Recorder is a metrics backend.
+// Names are snake case; durations are observed in seconds and end in "_seconds".
+type Recorder interface {
+	Count(name string, value float64, labels Labels)
+	Observe(name string, value float64, labels Labels)
+	// Flush sends anything buffered, it is called at the end of every Lambda invocation
+	Flush()
+}
+
 var (
-	cwClient *cloudwatch.CloudWatch
-	sess     *session.Session
+	recorder     Recorder
+	recorderOnce sync.Once
+	recorderMu   sync.RWMutex
 )
 
-// Initialize session and CloudWatch client in init function for reuse across Lambda invocations
-func init() {
-	sess = session.Must(session.NewSession())
-	cwClient = cloudwatch.New(sess)
-}
-
-// PublishCounterMetric publishes a counter metric to CloudWatch
-func PublishCounterMetric(metricName string, value float64) {
-	_, err := cwClient.PutMetricData(&cloudwatch.PutMetricDataInput{
-		Namespace: aws.String("SQSWorkerMetrics"),
-		MetricData: []*cloudwatch.MetricDatum{
-			{
-				MetricName: aws.String(metricName),
-				Unit:       aws.String("Count"),
-				Value:      aws.Float64(value),
-				Timestamp:  aws.Time(time.Now()),
-			},
-		},
+// Default returns the process wide recorder, created from METRICS_BACKEND on first use
+func Default() Recorder {
+	recorderOnce.Do(func() {
+		r := New(config.AppConfig.MetricsBackend)
+		recorderMu.Lock()
+		if recorder == nil {
+			recorder = r
+		}
+		recorderMu.Unlock()
 	})
-	if err != nil {
-		logrus.WithError(err).WithField("metric_name", metricName).Error("Failed to publish CloudWatch metric")
+
+	recorderMu.RLock()
+	defer recorderMu.RUnlock()
+	return recorder
+}
+
+// SetRecorder replaces the process wide recorder, tests use it to install a Memory recorder
+func SetRecorder(r Recorder) {
+	recorderOnce.Do(func() {})
+	recorderMu.Lock()
+	recorder = r
+	recorderMu.Unlock()
+}
+
+// New creates the recorder for a comma separated list of backends.
+// Unknown backends are logged and skipped; an empty list records nothing.
+func New(backends string) Recorder {
+	var recorders []Recorder
+	for _, backend := range strings.Split(backends, ",") {
+		switch strings.TrimSpace(backend) {
+		case "":
+		case "emf":
+			recorders = append(recorders, NewEMF(config.AppConfig.MetricsNamespace, os.Stdout, config.AppConfig.MetricsFlushInterval))
+		case "cloudwatch":
+			recorders = append(recorders, NewCloudWatch(config.AppConfig.MetricsNamespace, config.AppConfig.MetricsFlushInterval))
+		case "prometheus":
+			recorders = append(recorders, NewPrometheus())
+		case "memory":
+			recorders = append(recorders, NewMemory())
+		case "noop":
+			recorders = append(recorders, Noop{})
+		default:
+			logrus.Warnf("Unknown metrics backend %q, ignoring it", backend)
+		}
+	}
+
+	switch len(recorders) {
+	case 0:
+		return Noop{}
+	case 1:
+		return recorders[0]
+	default:
+		return Multi(recorders)
 	}
 }
 
-// PublishHistogramMetric publishes a histogram (duration) metric to CloudWatch
-func PublishHistogramMetric(metricName string, value float64) {
-	_, err := cwClient.PutMetricData(&cloudwatch.PutMetricDataInput{
-		Namespace: aws.String("SQSWorkerMetrics"),
-		MetricData: []*cloudwatch.MetricDatum{
-			{
-				MetricName: aws.String(metricName),
-				Unit:       aws.String("Seconds"),
-				Value:      aws.Float64(value),
-				Timestamp:  aws.Time(time.Now()),
-			},
-		},
-	})
-	if err != nil {
-		logrus.WithError(err).WithField("metric_name", metricName).Error("Failed to publish CloudWatch histogram metric")
+// Multi records to several backends at once
+type Multi []Recorder
+
+func (m Multi) Count(name string, value float64, labels Labels) {
+	for _, r := range m {
+		r.Count(name, value, labels)
+	}
+}
+
+func (m Multi) Observe(name string, value float64, labels Labels) {
+	for _, r := range m {
+		r.Observe(name, value, labels)
+	}
+}
+
+func (m Multi) Flush() {
+	for _, r := range m {
+		r.Flush()
 	}
 }
 
+// Noop discards every metric
+type Noop struct{}
+
+func (Noop) Count(string, float64, Labels)   {}
+func (Noop) Observe(string, float64, Labels) {}
+func (Noop) Flush()                          {}
+
+// Flush sends the metrics buffered by the default recorder
+func Flush() {
+	Default().Flush()
+}
+
 func IncrementMessagesReceived() {
-	PublishCounterMetric("MessagesReceived", 1)
+	Default().Count("messages_received", 1, nil)
 }
 
 func IncrementMessagesProcessed() {
-	PublishCounterMetric("MessagesProcessed", 1)
+	Default().Count("messages_processed", 1, nil)
+}
+
+func IncrementMessagesFailed(reason string) {
+	Default().Count("messages_failed", 1, Labels{"reason": reason})
+}
+
+func IncrementMessagesDeleted() {
+	Default().Count("messages_deleted", 1, nil)
 }
 
-func IncrementMessagesFailed() {
-	PublishCounterMetric("MessagesFailed", 1)
+func IncrementMessagesParsed(parser string) {
+	Default().Count("messages_parsed", 1, Labels{"parser": parser})
 }
 
-func IncrementMessagesParsed() {
-	PublishCounterMetric("MessagesParsed", 1)
+func IncrementMessagesIgnored(reason string) {
+	Default().Count("messages_ignored", 1, Labels{"reason": reason})
+}
+
+func IncrementMessagesDispatched(kind, result string) {
+	Default().Count("messages_dispatched", 1, Labels{"kind": kind, "result": result})
 }
 
 func ObserveProcessingDuration(durationSeconds float64) {
-	PublishHistogramMetric("ProcessingDuration", durationSeconds)
+	Default().Observe("processing_duration_seconds", durationSeconds, nil)
 }
 
-func IncrementMessagesDeleted() {
-	PublishCounterMetric("MessagesDeleted", 1)
+func ObserveStageDuration(stage string, durationSeconds float64) {
+	Default().Observe("stage_duration_seconds", durationSeconds, Labels{"stage": stage})
+}
+
+func ObserveGeocode(provider, result string, durationSeconds float64) {
+	Default().Count("geocode_requests", 1, Labels{"provider": provider, "result": result})
+	Default().Observe("geocode_duration_seconds", durationSeconds, Labels{"provider": provider})
+}
+
+func ObserveDBQuery(operation string, durationSeconds float64) {
+	Default().Observe("db_query_duration_seconds", durationSeconds, Labels{"operation": operation})
+}
+
+// ObserveFieldFill records whether a parse filled in a field, 1 or 0, so the average is the fill rate
+func ObserveFieldFill(parser, field string, populated float64) {
+	Default().Observe("field_fill_ratio", populated, Labels{"parser": parser, "field": field})
 }
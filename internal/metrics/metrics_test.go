@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestHelpersRecordToDefault(t *testing.T) {
+	memory := NewMemory()
+	SetRecorder(memory)
+	defer SetRecorder(Noop{})
+
+	IncrementMessagesReceived()
+	IncrementMessagesFailed("timeout")
+	IncrementMessagesFailed("timeout")
+	IncrementMessagesDispatched("platform", "ok")
+	ObserveStageDuration("geocode", 0.25)
+
+	tests := []struct {
+		name   string
+		labels Labels
+		want   float64
+	}{
+		{name: "messages_received", want: 1},
+		{name: "messages_failed", labels: Labels{"reason": "timeout"}, want: 2},
+		{name: "messages_failed", labels: Labels{"reason": "other"}, want: 0},
+		{name: "messages_dispatched", labels: Labels{"kind": "platform", "result": "ok"}, want: 1},
+	}
+	for _, tt := range tests {
+		if got := memory.Counter(tt.name, tt.labels); got != tt.want {
+			t.Errorf("Counter(%s, %v) = %v, want %v", tt.name, tt.labels, got, tt.want)
+		}
+	}
+	if got := memory.Observations("stage_duration_seconds", Labels{"stage": "geocode"}); !reflect.DeepEqual(got, []float64{0.25}) {
+		t.Errorf("Observations() = %v, want [0.25]", got)
+	}
+}
+
+func TestCloudWatchName(t *testing.T) {
+	tests := []struct {
+		name     string
+		counter  bool
+		wantName string
+		wantUnit string
+	}{
+		{name: "messages_failed", counter: true, wantName: "MessagesFailed", wantUnit: "Count"},
+		{name: "processing_duration_seconds", wantName: "ProcessingDuration", wantUnit: "Seconds"},
+		{name: "field_fill_ratio", wantName: "FieldFillRatio", wantUnit: "None"},
+	}
+	for _, tt := range tests {
+		name, unit := cloudWatchName(tt.name, tt.counter)
+		if name != tt.wantName || unit != tt.wantUnit {
+			t.Errorf("cloudWatchName(%s) = %s %s, want %s %s", tt.name, name, unit, tt.wantName, tt.wantUnit)
+		}
+	}
+}
+
+func TestCloudWatchKeepsDimensionlessSeries(t *testing.T) {
+	c := NewCloudWatch("Test", 0)
+	c.Count("messages_failed", 1, Labels{"reason": "timeout"})
+	c.Count("messages_failed", 1, Labels{"reason": "geocode"})
+	c.Count("messages_processed", 1, nil)
+
+	tests := []struct {
+		key        string
+		dimensions int
+		sum        float64
+	}{
+		{key: `messages_failed{reason="timeout"}`, dimensions: 1, sum: 1},
+		{key: `messages_failed{reason="geocode"}`, dimensions: 1, sum: 1},
+		{key: "messages_failed", dimensions: 0, sum: 2},
+		{key: "messages_processed", dimensions: 0, sum: 1},
+	}
+	if len(c.pending) != len(tests) {
+		t.Errorf("pending series = %d, want %d", len(c.pending), len(tests))
+	}
+	for _, tt := range tests {
+		series, ok := c.pending[tt.key]
+		if !ok {
+			t.Errorf("series %s missing", tt.key)
+			continue
+		}
+		if len(series.dimensions) != tt.dimensions || series.sum != tt.sum {
+			t.Errorf("series %s = %d dimensions sum %v, want %d dimensions sum %v", tt.key, len(series.dimensions), series.sum, tt.dimensions, tt.sum)
+		}
+	}
+}
+
+func TestServeReportsBindError(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	defer taken.Close()
+
+	if err := Serve(taken.Addr().String()); err == nil {
+		t.Error("Serve() on a port in use returned no error")
+	}
+	if err := Serve(""); err != nil {
+		t.Errorf("Serve(\"\") error = %v, want the listener disabled", err)
+	}
+}
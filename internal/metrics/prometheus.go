@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// promNamespace prefixes every metric, "messages_received" is exported as parser_messages_received_total
+const promNamespace = "parser"
+
+// promHelp describes the metrics we know about, others get their name as help text
+var promHelp = map[string]string{
+	"messages_received":           "SQS messages received by the worker.",
+	"messages_processed":          "SQS messages the worker processed successfully.",
+	"messages_failed":             "SQS messages the worker failed to process, by reason.",
+	"messages_deleted":            "SQS messages deleted from the queue after processing.",
+	"messages_parsed":             "Emails parsed into an order message, by parser.",
+	"messages_ignored":            "Emails that did not become an order, by reason.",
+	"messages_dispatched":         "Outbox deliveries, by kind and result.",
+	"processing_duration_seconds": "Time the worker spends on one SQS message.",
+	"stage_duration_seconds":      "Time the worker spends in each stage of processing a message, by stage.",
+	"geocode_requests":            "Requests to the geocoding provider, by provider and result.",
+	"geocode_duration_seconds":    "Latency of requests to the geocoding provider.",
+	"db_query_duration_seconds":   "Latency of database statements, by operation.",
+	"field_fill_ratio":            "Whether a parse filled in a field, by parser and field. sum/count is the fill rate.",
+}
+
+// promBuckets overrides the default histogram buckets for fast operations
+var promBuckets = map[string][]float64{
+	"db_query_duration_seconds": {.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	"stage_duration_seconds":    {.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	// Samples are 0 or 1, only sum and count matter
+	"field_fill_ratio": {0.5},
+}
+
+// Prometheus registers a collector the first time a metric is recorded
+type Prometheus struct {
+	registerer prometheus.Registerer
+	mu         sync.Mutex
+	counters   map[string]*prometheus.CounterVec
+	histograms map[string]*prometheus.HistogramVec
+}
+
+var (
+	promDefault     *Prometheus
+	promDefaultOnce sync.Once
+)
+
+// NewPrometheus returns the recorder for the default registry, which Serve exposes.
+// There is only one per process since a collector can be registered only once.
+func NewPrometheus() *Prometheus {
+	promDefaultOnce.Do(func() {
+		promDefault = &Prometheus{
+			registerer: prometheus.DefaultRegisterer,
+			counters:   map[string]*prometheus.CounterVec{},
+			histograms: map[string]*prometheus.HistogramVec{},
+		}
+	})
+	return promDefault
+}
+
+func (p *Prometheus) Count(name string, value float64, labels Labels) {
+	p.mu.Lock()
+	vec, ok := p.counters[name]
+	if !ok {
+		vec = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: promNamespace,
+			Name:      name + "_total",
+			Help:      help(name),
+		}, labelNames(labels))
+		if err := p.registerer.Register(vec); err != nil {
+			p.mu.Unlock()
+			logrus.WithField("metric", name).Warn("Failed to register Prometheus counter: ", err)
+			return
+		}
+		p.counters[name] = vec
+	}
+	p.mu.Unlock()
+
+	counter, err := vec.GetMetricWith(prometheus.Labels(labels))
+	if err != nil {
+		logrus.WithField("metric", name).Warn("Invalid Prometheus labels: ", err)
+		return
+	}
+	counter.Add(value)
+}
+
+func (p *Prometheus) Observe(name string, value float64, labels Labels) {
+	p.mu.Lock()
+	vec, ok := p.histograms[name]
+	if !ok {
+		buckets, ok := promBuckets[name]
+		if !ok {
+			buckets = prometheus.DefBuckets
+		}
+		vec = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: promNamespace,
+			Name:      name,
+			Help:      help(name),
+			Buckets:   buckets,
+		}, labelNames(labels))
+		if err := p.registerer.Register(vec); err != nil {
+			p.mu.Unlock()
+			logrus.WithField("metric", name).Warn("Failed to register Prometheus histogram: ", err)
+			return
+		}
+		p.histograms[name] = vec
+	}
+	p.mu.Unlock()
+
+	histogram, err := vec.GetMetricWith(prometheus.Labels(labels))
+	if err != nil {
+		logrus.WithField("metric", name).Warn("Invalid Prometheus labels: ", err)
+		return
+	}
+	histogram.Observe(value)
+}
+
+// Flush does nothing, Prometheus pulls
+func (p *Prometheus) Flush() {}
+
+func help(name string) string {
+	if h, ok := promHelp[name]; ok {
+		return h
+	}
+	return name
+}
+
+func labelNames(labels Labels) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Serve exposes the Prometheus metrics on addr at /metrics in the background.
+// An empty addr disables the listener. The address is bound before Serve returns,
+// so a port already in use is reported to the caller instead of only being logged.
+func Serve(addr string) error {
+	if addr == "" {
+		logrus.Info("Metrics listener disabled")
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for metrics on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		logrus.Infof("Serving metrics on %s/metrics", addr)
+		if err := http.Serve(listener, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.Error("Metrics listener stopped: ", err)
+		}
+	}()
+	return nil
+}
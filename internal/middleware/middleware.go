@@ -1,10 +1,14 @@
 package middleware
 
 import (
+	"crypto/subtle"
+	"strings"
 	"time"
 
+	config "github.com/3milly4ever/parser-landstar/pkg"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/sirupsen/logrus"
 )
 
 var lastRequestTime time.Time
@@ -47,6 +51,24 @@ var requestsData []map[string]string
 // 	}
 // }
 
+// AdminAuth only lets requests through that carry ADMIN_TOKEN as a bearer token.
+// The admin endpoints are disabled while ADMIN_TOKEN is not set.
+func AdminAuth() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := config.AppConfig.AdminToken
+		if token == "" {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "admin endpoints are disabled"})
+		}
+
+		provided, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			logrus.WithFields(logrus.Fields{"path": c.Path(), "ip": c.IP()}).Warn("Rejected admin request")
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		return c.Next()
+	}
+}
+
 func CORS() fiber.Handler {
 	return cors.New(cors.Config{
 		AllowOrigins: "*", // Allow all origins, customize as needed
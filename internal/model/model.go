@@ -1,6 +1,24 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Order statuses managed by the parser
+const (
+	OrderStatusActive    = "active"
+	OrderStatusCancelled = "cancelled"
+)
+
+// Geocoding states of an order location.
+// Pending locations are retried in the background until they succeed or run out of attempts.
+const (
+	GeocodeStatusOK      = "ok"
+	GeocodeStatusPending = "pending"
+	GeocodeStatusFailed  = "failed"
+)
 
 type Order struct {
 	ID                 int       `gorm:"primaryKey;autoIncrement" json:"id"`
@@ -19,6 +37,8 @@ type Order struct {
 	EstimatedMiles     int       `json:"estimated_miles"`
 	TruckTypeID        int       `json:"truck_type_id"`
 	OriginalTruckSize  string    `json:"original_truck_size"`
+	Status             string    `gorm:"column:status;type:varchar(32);default:active" json:"status"`
+	MilesEstimated     bool      `gorm:"column:miles_estimated;default:false" json:"miles_estimated"`
 }
 
 type ParserLog struct {
@@ -29,6 +49,7 @@ type ParserLog struct {
 	ErrorText  string    `gorm:"column:error_text;type:text"`
 	OrderID    int       `gorm:"column:order_id"`
 	ParserID   uint64    `gorm:"column:parser_id"`
+	Parser     string    `gorm:"column:parser;type:varchar(64);index"`
 	ParserType string    `gorm:"column:parser_type;type:enum('mail','api')"`
 	Subject    string    `gorm:"column:subject;type:text"`
 	CreatedAt  time.Time `gorm:"column:created_at"`
@@ -69,6 +90,11 @@ type OrderLocation struct {
 	EstimatedMiles      float64   `gorm:"column:estimated_miles" json:"estimated_miles"`
 	UpdatedAt           time.Time `gorm:"column:updated_at" json:"updated_at"`
 	CreatedAt           time.Time `gorm:"column:created_at" json:"created_at"`
+
+	GeocodeStatus        string     `gorm:"column:geocode_status;type:varchar(16);index" json:"geocode_status"`
+	GeocodeAttempts      int        `gorm:"column:geocode_attempts;default:0" json:"geocode_attempts"`
+	GeocodeNextAttemptAt *time.Time `gorm:"column:geocode_next_attempt_at" json:"geocode_next_attempt_at"`
+	GeocodeError         string     `gorm:"column:geocode_error;type:text" json:"geocode_error"`
 }
 
 type OrderItem struct {
@@ -99,3 +125,220 @@ type OrderEmail struct {
 func (OrderEmail) TableName() string {
 	return "order_email"
 }
+
+// OrderAudit records every change the parser applies to an existing order
+type OrderAudit struct {
+	ID          int       `gorm:"primaryKey;autoIncrement" json:"id"`
+	OrderID     int       `gorm:"column:order_id;index" json:"order_id"`
+	ParserLogID int       `gorm:"column:parser_log_id" json:"parser_log_id"`
+	Action      string    `gorm:"column:action;type:varchar(32)" json:"action"`
+	Changes     string    `gorm:"column:changes;type:text" json:"changes"`
+	CreatedAt   time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+// TableName overrides the default table name used by Gorm
+func (OrderAudit) TableName() string {
+	return "order_audit"
+}
+
+// GeocodeCache stores geocoding results by normalized address.
+// NotFound rows are negative entries for addresses the provider had no match for.
+type GeocodeCache struct {
+	ID         int       `gorm:"primaryKey;autoIncrement" json:"id"`
+	Address    string    `gorm:"column:address;type:varchar(255);uniqueIndex" json:"address"`
+	Lat        float64   `gorm:"column:lat" json:"lat"`
+	Lng        float64   `gorm:"column:lng" json:"lng"`
+	County     string    `gorm:"column:county;type:varchar(255)" json:"county"`
+	PostalCode string    `gorm:"column:postal_code;type:varchar(32)" json:"postal_code"`
+	Provider   string    `gorm:"column:provider;type:varchar(32)" json:"provider"`
+	NotFound   bool      `gorm:"column:not_found" json:"not_found"`
+	ExpiresAt  time.Time `gorm:"column:expires_at;index" json:"expires_at"`
+	CreatedAt  time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt  time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName overrides the default table name used by Gorm
+func (GeocodeCache) TableName() string {
+	return "geocode_cache"
+}
+
+// Outbox message states
+const (
+	OutboxStatusPending   = "pending"
+	OutboxStatusDelivered = "delivered"
+	OutboxStatusDead      = "dead"
+)
+
+// OutboxMessage is a side effect of an order change waiting to be delivered,
+// written in the same transaction as the order
+type OutboxMessage struct {
+	ID            int        `gorm:"primaryKey;autoIncrement" json:"id"`
+	Kind          string     `gorm:"column:kind;type:varchar(64);index" json:"kind"`
+	OrderID       int        `gorm:"column:order_id;index" json:"order_id"`
+	Payload       string     `gorm:"column:payload;type:text" json:"payload"`
+	Status        string     `gorm:"column:status;type:varchar(16);index:idx_outbox_due,priority:1" json:"status"`
+	Attempts      int        `gorm:"column:attempts" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"column:next_attempt_at;index:idx_outbox_due,priority:2" json:"next_attempt_at"`
+	LastError     string     `gorm:"column:last_error;type:text" json:"last_error"`
+	DeliveredAt   *time.Time `gorm:"column:delivered_at" json:"delivered_at"`
+	CreatedAt     time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName overrides the default table name used by Gorm
+func (OutboxMessage) TableName() string {
+	return "outbox"
+}
+
+// WebhookSubscription is a downstream system that wants order events pushed to it.
+// Events is a comma separated list of event names, "*" subscribes to all of them.
+type WebhookSubscription struct {
+	ID        int       `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name      string    `gorm:"column:name;type:varchar(255)" json:"name"`
+	URL       string    `gorm:"column:url;type:varchar(1024)" json:"url"`
+	Events    string    `gorm:"column:events;type:varchar(255)" json:"events"`
+	Secret    string    `gorm:"column:secret;type:varchar(255)" json:"-"`
+	Active    bool      `gorm:"column:active;default:true" json:"active"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName overrides the default table name used by Gorm
+func (WebhookSubscription) TableName() string {
+	return "webhook_subscription"
+}
+
+// WebhookDelivery records one attempt to deliver an event to a subscription
+type WebhookDelivery struct {
+	ID             int       `gorm:"primaryKey;autoIncrement" json:"id"`
+	SubscriptionID int       `gorm:"column:subscription_id;index" json:"subscription_id"`
+	OutboxID       int       `gorm:"column:outbox_id;index" json:"outbox_id"`
+	Event          string    `gorm:"column:event;type:varchar(64)" json:"event"`
+	OrderID        int       `gorm:"column:order_id" json:"order_id"`
+	StatusCode     int       `gorm:"column:status_code" json:"status_code"`
+	Error          string    `gorm:"column:error;type:text" json:"error"`
+	DurationMs     int64     `gorm:"column:duration_ms" json:"duration_ms"`
+	CreatedAt      time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+// TableName overrides the default table name used by Gorm
+func (WebhookDelivery) TableName() string {
+	return "webhook_delivery"
+}
+
+// Failed message states
+const (
+	FailedMessageOpen     = "open"
+	FailedMessageReplayed = "replayed"
+	FailedMessageResolved = "resolved"
+)
+
+// FailedMessage keeps the payload of an SQS message the worker could not process,
+// so it can be inspected, corrected and replayed after it leaves the queue.
+// Resolved means a later SQS redelivery succeeded on its own.
+type FailedMessage struct {
+	ID            int        `gorm:"primaryKey;autoIncrement" json:"id"`
+	MessageID     string     `gorm:"column:message_id;type:varchar(128);uniqueIndex" json:"message_id"`
+	Body          string     `gorm:"column:body;type:mediumtext" json:"body"`
+	ErrorClass    string     `gorm:"column:error_class;type:varchar(64);index" json:"error_class"`
+	ErrorText     string     `gorm:"column:error_text;type:text" json:"error_text"`
+	Attempts      int        `gorm:"column:attempts" json:"attempts"`
+	Status        string     `gorm:"column:status;type:varchar(16);index" json:"status"`
+	FirstFailedAt time.Time  `gorm:"column:first_failed_at" json:"first_failed_at"`
+	LastFailedAt  time.Time  `gorm:"column:last_failed_at" json:"last_failed_at"`
+	ReplayedAt    *time.Time `gorm:"column:replayed_at" json:"replayed_at"`
+	CreatedAt     time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName overrides the default table name used by Gorm
+func (FailedMessage) TableName() string {
+	return "failed_messages"
+}
+
+// ParserFieldStat counts, per parser and hour, how many parses filled in a field
+type ParserFieldStat struct {
+	ID          int       `gorm:"primaryKey;autoIncrement" json:"id"`
+	Parser      string    `gorm:"column:parser;type:varchar(64);uniqueIndex:idx_parser_field_bucket,priority:1" json:"parser"`
+	Field       string    `gorm:"column:field;type:varchar(64);uniqueIndex:idx_parser_field_bucket,priority:2" json:"field"`
+	BucketStart time.Time `gorm:"column:bucket_start;uniqueIndex:idx_parser_field_bucket,priority:3;index" json:"bucket_start"`
+	Total       int       `gorm:"column:total" json:"total"`
+	Populated   int       `gorm:"column:populated" json:"populated"`
+}
+
+// TableName overrides the default table name used by Gorm
+func (ParserFieldStat) TableName() string {
+	return "parser_field_stats"
+}
+
+// ParserTemplateSample records the structure of one parsed email and whether the parse succeeded
+type ParserTemplateSample struct {
+	ID            int       `gorm:"primaryKey;autoIncrement" json:"id"`
+	Parser        string    `gorm:"column:parser;type:varchar(64);index:idx_template_sample_parser_created,priority:1" json:"parser"`
+	ParserLogID   int       `gorm:"column:parser_log_id;index" json:"parser_log_id"`
+	Fingerprint   string    `gorm:"column:fingerprint;type:varchar(32)" json:"fingerprint"`
+	Structure     string    `gorm:"column:structure;type:text" json:"structure"`
+	Success       bool      `gorm:"column:success" json:"success"`
+	MissingFields string    `gorm:"column:missing_fields;type:varchar(512)" json:"missing_fields"`
+	CreatedAt     time.Time `gorm:"column:created_at;index:idx_template_sample_parser_created,priority:2" json:"created_at"`
+}
+
+// TableName overrides the default table name used by Gorm
+func (ParserTemplateSample) TableName() string {
+	return "parser_template_sample"
+}
+
+// DriftAlert is a template drift alert the evaluator raised
+type DriftAlert struct {
+	ID        int       `gorm:"primaryKey;autoIncrement" json:"id"`
+	Parser    string    `gorm:"column:parser;type:varchar(64);index:idx_drift_alert_lookup,priority:1" json:"parser"`
+	Kind      string    `gorm:"column:kind;type:varchar(32);index:idx_drift_alert_lookup,priority:2" json:"kind"`
+	Subject   string    `gorm:"column:subject;type:varchar(64);index:idx_drift_alert_lookup,priority:3" json:"subject"`
+	Payload   string    `gorm:"column:payload;type:text" json:"payload"`
+	CreatedAt time.Time `gorm:"column:created_at;index" json:"created_at"`
+}
+
+// TableName overrides the default table name used by Gorm
+func (DriftAlert) TableName() string {
+	return "drift_alert"
+}
+
+// Migrate creates the tables and columns owned by the parser.
+// The orders table itself belongs to the platform, so only the columns we added are touched.
+func Migrate(db *gorm.DB) error {
+	for _, field := range []string{"Status", "MilesEstimated"} {
+		if !db.Migrator().HasColumn(&Order{}, field) {
+			if err := db.Migrator().AddColumn(&Order{}, field); err != nil {
+				return err
+			}
+		}
+	}
+	for _, field := range []string{"GeocodeStatus", "GeocodeAttempts", "GeocodeNextAttemptAt", "GeocodeError"} {
+		if !db.Migrator().HasColumn(&OrderLocation{}, field) {
+			if err := db.Migrator().AddColumn(&OrderLocation{}, field); err != nil {
+				return err
+			}
+		}
+	}
+	if !db.Migrator().HasColumn(&ParserLog{}, "Parser") {
+		if err := db.Migrator().AddColumn(&ParserLog{}, "Parser"); err != nil {
+			return err
+		}
+		if err := db.Migrator().CreateIndex(&ParserLog{}, "Parser"); err != nil {
+			return err
+		}
+	}
+	if err := backfillParser(db); err != nil {
+		return err
+	}
+	return db.AutoMigrate(&OrderAudit{}, &GeocodeCache{}, &OutboxMessage{}, &WebhookSubscription{}, &WebhookDelivery{}, &FailedMessage{}, &ParserFieldStat{}, &ParserTemplateSample{}, &DriftAlert{})
+}
+
+// backfillParser names the parser on parser_log rows written before the column existed.
+// Both parsers shared parser_id 4, so the order type tells them apart: Landstar orders are type 5.
+// Updates and cancels look orders up by parser, so without this they would miss older orders.
+func backfillParser(db *gorm.DB) error {
+	return db.Exec(`UPDATE parser_log JOIN orders ON orders.id = parser_log.order_id
+		SET parser_log.parser = CASE orders.order_type_id WHEN 5 THEN 'landstar' ELSE 'fullcircle' END
+		WHERE parser_log.parser IS NULL OR parser_log.parser = ''`).Error
+}
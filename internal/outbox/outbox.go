@@ -0,0 +1,237 @@
+// Package outbox delivers side effects of an order, such as notifying the platform,
+// after the transaction that saved the order has committed.
+//
+// Messages are written to the outbox table in the same transaction as the order, so
+// a saved order always has its notification queued and a rolled back one never does.
+// The dispatcher then delivers pending messages with exponential backoff and jitter
+// until they succeed or run out of attempts.
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/3milly4ever/parser-landstar/internal/log"
+	"github.com/3milly4ever/parser-landstar/internal/metrics"
+	models "github.com/3milly4ever/parser-landstar/internal/model"
+	config "github.com/3milly4ever/parser-landstar/pkg"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// KindPlatformOrder tells the platform that an order was created or changed
+const KindPlatformOrder = "platform_order"
+
+// claimLease is how long a dispatcher owns a message it is delivering,
+// another dispatcher may pick it up again after that
+const claimLease = time.Minute
+
+// DeliverFunc performs the side effect of an outbox message, db is the dispatcher's connection
+type DeliverFunc func(ctx context.Context, db *gorm.DB, msg *models.OutboxMessage) error
+
+var (
+	handlers   = map[string]DeliverFunc{}
+	handlersMu sync.RWMutex
+)
+
+// Register sets the function that delivers messages of a kind
+func Register(kind string, deliver DeliverFunc) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[kind] = deliver
+}
+
+// Enqueue adds a message to the outbox, tx should be the transaction that saves the order
+func Enqueue(tx *gorm.DB, kind string, orderID int, payload string) (*models.OutboxMessage, error) {
+	// MySQL keeps milliseconds, truncate so the stored time is never later than the one we hold
+	now := time.Now().Truncate(time.Millisecond)
+	msg := &models.OutboxMessage{
+		Kind:          kind,
+		OrderID:       orderID,
+		Payload:       payload,
+		Status:        models.OutboxStatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := tx.Create(msg).Error; err != nil {
+		return nil, fmt.Errorf("failed to enqueue %s message: %w", kind, err)
+	}
+	return msg, nil
+}
+
+// Deliver makes one delivery attempt of a pending message and records the outcome.
+// The returned error is the delivery error; the message is already rescheduled or marked dead by then.
+func Deliver(ctx context.Context, db *gorm.DB, msg *models.OutboxMessage) error {
+	if !claim(db, msg) {
+		// Another dispatcher got to it first
+		return nil
+	}
+
+	handlersMu.RLock()
+	deliver, ok := handlers[msg.Kind]
+	handlersMu.RUnlock()
+
+	var err error
+	if !ok {
+		err = fmt.Errorf("no handler registered for outbox kind %q", msg.Kind)
+	} else {
+		err = deliver(ctx, db, msg)
+	}
+
+	logger := log.FromContext(ctx).WithFields(logrus.Fields{
+		"outbox_id":      msg.ID,
+		"kind":           msg.Kind,
+		log.FieldOrderID: msg.OrderID,
+	})
+
+	now := time.Now()
+	msg.Attempts++
+	msg.UpdatedAt = now
+	switch {
+	case err == nil:
+		metrics.IncrementMessagesDispatched(msg.Kind, "delivered")
+		msg.Status = models.OutboxStatusDelivered
+		msg.LastError = ""
+		msg.DeliveredAt = &now
+		logger.Info("Outbox message delivered")
+	case msg.Attempts >= config.AppConfig.OutboxMaxAttempts:
+		metrics.IncrementMessagesDispatched(msg.Kind, "dead")
+		msg.Status = models.OutboxStatusDead
+		msg.LastError = err.Error()
+		logger.Errorf("Outbox message failed %d times, giving up: %v", msg.Attempts, err)
+	default:
+		metrics.IncrementMessagesDispatched(msg.Kind, "retry")
+		msg.LastError = err.Error()
+		msg.NextAttemptAt = now.Add(backoff(msg.Attempts))
+		logger.Warnf("Outbox message delivery failed, retrying at %s: %v", msg.NextAttemptAt.Format(time.RFC3339), err)
+	}
+
+	// Use a fresh context so the outcome is recorded even when ctx has expired
+	if saveErr := db.WithContext(context.Background()).Select("status", "attempts", "next_attempt_at", "last_error", "delivered_at", "updated_at").Updates(msg).Error; saveErr != nil {
+		logger.Error("Failed to record outbox delivery outcome: ", saveErr)
+	}
+	return err
+}
+
+// claim takes ownership of a due message for claimLease by moving its next attempt forward.
+// The update only matches while the message is due, so once one dispatcher claimed it the others skip it.
+func claim(db *gorm.DB, msg *models.OutboxMessage) bool {
+	now := time.Now()
+	lease := now.Add(claimLease)
+	result := db.Model(&models.OutboxMessage{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", msg.ID, models.OutboxStatusPending, now).
+		Update("next_attempt_at", lease)
+	if result.Error != nil {
+		logrus.WithField("outbox_id", msg.ID).Error("Failed to claim outbox message: ", result.Error)
+		return false
+	}
+	if result.RowsAffected == 0 {
+		return false
+	}
+	msg.NextAttemptAt = lease
+	return true
+}
+
+// backoff doubles the delay after every failed attempt up to OUTBOX_MAX_DELAY,
+// then picks a random point in the upper half so failed messages do not retry in lockstep
+func backoff(attempts int) time.Duration {
+	delay := config.AppConfig.OutboxBaseDelay
+	for i := 1; i < attempts && delay < config.AppConfig.OutboxMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > config.AppConfig.OutboxMaxDelay {
+		delay = config.AppConfig.OutboxMaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Dispatch delivers one batch of due messages and returns how many it attempted
+func Dispatch(ctx context.Context, db *gorm.DB) (int, error) {
+	var due []models.OutboxMessage
+	err := db.WithContext(ctx).
+		Where("status = ? AND next_attempt_at <= ?", models.OutboxStatusPending, time.Now()).
+		Order("next_attempt_at").
+		Limit(config.AppConfig.OutboxBatchSize).
+		Find(&due).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to load outbox messages: %w", err)
+	}
+
+	for i := range due {
+		if ctx.Err() != nil {
+			return i, ctx.Err()
+		}
+		Deliver(ctx, db.WithContext(ctx), &due[i])
+	}
+	return len(due), nil
+}
+
+// Run dispatches due messages every OUTBOX_POLL_INTERVAL until ctx is cancelled
+func Run(ctx context.Context, db *gorm.DB) {
+	ticker := time.NewTicker(config.AppConfig.OutboxPollInterval)
+	defer ticker.Stop()
+
+	logrus.Infof("Outbox dispatcher started, polling every %s", config.AppConfig.OutboxPollInterval)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := Dispatch(ctx, db); err != nil {
+				logrus.Error("Outbox dispatch failed: ", err)
+			}
+		}
+	}
+}
+
+// KindStatus is the number of messages of a kind in a status
+type KindStatus struct {
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+	Count  int64  `json:"count"`
+}
+
+// State summarizes the outbox for operators
+type State struct {
+	Counts        []KindStatus           `json:"counts"`
+	OldestPending *time.Time             `json:"oldest_pending,omitempty"`
+	RecentDead    []models.OutboxMessage `json:"recent_dead"`
+}
+
+// GetState returns the message counts per kind and status, the age of the oldest
+// pending message and the most recent messages that ran out of attempts
+func GetState(ctx context.Context, db *gorm.DB) (*State, error) {
+	db = db.WithContext(ctx)
+	state := &State{}
+
+	err := db.Model(&models.OutboxMessage{}).
+		Select("kind, status, COUNT(*) AS count").
+		Group("kind, status").
+		Scan(&state.Counts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var oldest models.OutboxMessage
+	result := db.Where("status = ?", models.OutboxStatusPending).Order("created_at").Limit(1).Find(&oldest)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected > 0 {
+		state.OldestPending = &oldest.CreatedAt
+	}
+
+	err = db.Where("status = ?", models.OutboxStatusDead).Order("updated_at DESC").Limit(20).Find(&state.RecentDead).Error
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
@@ -0,0 +1,45 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+
+	config "github.com/3milly4ever/parser-landstar/pkg"
+)
+
+func TestBackoff(t *testing.T) {
+	config.AppConfig.OutboxBaseDelay = 5 * time.Second
+	config.AppConfig.OutboxMaxDelay = time.Minute
+
+	tests := []struct {
+		attempts int
+		// The full delay, backoff picks a random delay between half of it and all of it
+		want time.Duration
+	}{
+		{attempts: 0, want: 5 * time.Second},
+		{attempts: 1, want: 5 * time.Second},
+		{attempts: 2, want: 10 * time.Second},
+		{attempts: 3, want: 20 * time.Second},
+		{attempts: 4, want: 40 * time.Second},
+		{attempts: 5, want: time.Minute},
+		{attempts: 50, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			got := backoff(tt.attempts)
+			if got < tt.want/2 || got > tt.want {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", tt.attempts, got, tt.want/2, tt.want)
+			}
+		}
+	}
+}
+
+func TestBackoffWithoutDelay(t *testing.T) {
+	config.AppConfig.OutboxBaseDelay = 0
+	config.AppConfig.OutboxMaxDelay = time.Minute
+
+	if got := backoff(3); got != 0 {
+		t.Errorf("backoff(3) = %s, want 0", got)
+	}
+}
@@ -0,0 +1,82 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// EmailAction describes what a load email asks us to do with the order it refers to
+type EmailAction string
+
+const (
+	ActionNew    EmailAction = "new"
+	ActionUpdate EmailAction = "update"
+	ActionCancel EmailAction = "cancel"
+)
+
+// Subject keywords brokers use when a load is withdrawn or changed.
+// The subject is checked first because the body of a normal posting can
+// legitimately contain words like "cancel" in its terms and conditions.
+// Words such as "covered" or "change" show up in ordinary postings ("Covered
+// van", "Lane change"), so they only count as part of a phrase about the load.
+var (
+	cancelSubjectRegex = regexp.MustCompile(`(?i)\b(cancel+ed|cancel+ation|cancel|no longer available|load removed|(load|shipment|order)( #?[\w-]*\d[\w-]*)? (is |has been |was )?covered)\b`)
+	updateSubjectRegex = regexp.MustCompile(`(?i)(\b(updated|revised|revision|amended|corrected)\b|\b(load|order|shipment) (update|change)\b|^\s*(re:\s*|fw:\s*)*(update|change)\s*[:\-])`)
+	cancelBodyRegex    = regexp.MustCompile(`(?i)(this load (has been|was|is) (cancel+ed|covered)|load (is|has been) no longer available)`)
+	updateBodyRegex    = regexp.MustCompile(`(?i)(this load (has been|was) (updated|revised|changed))`)
+)
+
+// ClassifyEmail decides whether an email is a new load, an update to a load
+// we already have, or a cancellation
+func ClassifyEmail(subject, bodyHTML, bodyPlain string) EmailAction {
+	if cancelSubjectRegex.MatchString(subject) {
+		return ActionCancel
+	}
+	if updateSubjectRegex.MatchString(subject) {
+		return ActionUpdate
+	}
+
+	body := bodyPlain
+	if body == "" && bodyHTML != "" {
+		body = stripHTMLTags(bodyHTML)
+	}
+	if cancelBodyRegex.MatchString(body) {
+		return ActionCancel
+	}
+	if updateBodyRegex.MatchString(body) {
+		return ActionUpdate
+	}
+
+	return ActionNew
+}
+
+// ExtractOrderNumberFromEmail finds the order number using every layout we know about.
+// Cancellation emails are usually too short to go through a full parse, so this
+// is used to find the order they refer to.
+func ExtractOrderNumberFromEmail(bodyHTML, bodyPlain string) string {
+	if bodyHTML != "" {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(bodyHTML))
+		if err == nil {
+			if orderNumber := ExtractOrderNumberFromLandstarHTML(doc); orderNumber != "" {
+				return orderNumber
+			}
+			if orderNumber := ExtractOrderNumberFromHTML(doc); orderNumber != "" {
+				return orderNumber
+			}
+		}
+	}
+
+	if orderNumber := ExtractOrderNumber(bodyPlain); orderNumber != "" {
+		return orderNumber
+	}
+
+	// Landstar plain text bodies use the same "Load #" label as the HTML
+	re := regexp.MustCompile(`(?i)Load\s*#\s*:?\s*(\w+)`)
+	matches := re.FindStringSubmatch(bodyPlain)
+	if len(matches) > 1 {
+		return matches[1]
+	}
+	return ""
+}
@@ -0,0 +1,55 @@
+package parser
+
+import "testing"
+
+func TestClassifyEmail(t *testing.T) {
+	tests := []struct {
+		name      string
+		subject   string
+		bodyHTML  string
+		bodyPlain string
+		want      EmailAction
+	}{
+		{name: "new load", subject: "SMALL STRAIGHT from MONTEREY, CA to NORTH LAS VEGAS, NV - Alliance Posted Load", want: ActionNew},
+		{name: "covered van is a new load", subject: "Covered van load Dallas to Austin", want: ActionNew},
+		{name: "lane change is a new load", subject: "Lane change: new loads available", want: ActionNew},
+		{name: "change of plans is a new load", subject: "New load available, change of plans", want: ActionNew},
+		{name: "cancelled", subject: "CANCELLED - Load 55", want: ActionCancel},
+		{name: "canceled", subject: "Load 55 canceled", want: ActionCancel},
+		{name: "cancellation", subject: "Cancellation notice for order 55", want: ActionCancel},
+		{name: "load covered", subject: "Load 123 covered", want: ActionCancel},
+		{name: "load has been covered", subject: "Load #LS-123 has been covered", want: ActionCancel},
+		{name: "no longer available", subject: "Load 123 is no longer available", want: ActionCancel},
+		{name: "update prefix", subject: "Update: Load 123", want: ActionUpdate},
+		{name: "forwarded change prefix", subject: "RE: Change - pickup time", want: ActionUpdate},
+		{name: "revised", subject: "Revised pickup for 123", want: ActionUpdate},
+		{name: "load change", subject: "Load change for 123", want: ActionUpdate},
+		{name: "cancel wins over update", subject: "Updated: load cancelled", want: ActionCancel},
+		{
+			name:      "cancellation in plain body",
+			subject:   "Load 123",
+			bodyPlain: "Please note this load has been cancelled by the shipper.",
+			want:      ActionCancel,
+		},
+		{
+			name:     "update in html body",
+			subject:  "Load 123",
+			bodyHTML: "<p>This load has been <b>updated</b></p>",
+			want:     ActionUpdate,
+		},
+		{
+			name:      "cancel in terms is still a new load",
+			subject:   "Load 123",
+			bodyPlain: "Carriers may not cancel within 24 hours of pickup.",
+			want:      ActionNew,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyEmail(tt.subject, tt.bodyHTML, tt.bodyPlain); got != tt.want {
+				t.Errorf("ClassifyEmail(%q) = %s, want %s", tt.subject, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package parser
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/3milly4ever/parser-landstar/internal/log"
+	models "github.com/3milly4ever/parser-landstar/internal/model"
+	"github.com/PuerkitoBio/goquery"
+)
+
+// FullCircleParser parses FullCircle load emails, preferring the HTML body and
+// falling back to the plain text body when the HTML is missing or incomplete
+type FullCircleParser struct{}
+
+// truckSizeToTypeID maps FullCircle vehicle classes to our truck type IDs
+var truckSizeToTypeID = map[string]int{
+	"small straight":  1,
+	"large straight":  2,
+	"sprinter":        3,
+	"tractor trailer": 4,
+}
+
+// Parse parses the email content and returns a ParserResult
+func (p *FullCircleParser) Parse(ctx context.Context, bodyHTML, bodyPlain string) (*ParserResult, error) {
+	var (
+		orderNumber                                                                  string
+		pickupZip, pickupCity, pickupState, pickupCountry, pickupStateCode           string
+		deliveryZip, deliveryCity, deliveryState, deliveryCountry, deliveryStateCode string
+		pickupCountryCode, deliveryCountryCode                                       string
+		pickupDateTime, deliveryDateTime                                             time.Time
+		truckSize, notes                                                             string
+		originalTruckSize                                                            string
+		length, width, height, weight                                                float64
+		pieces                                                                       int
+		stackable, hazardous                                                         bool
+		estimatedMiles                                                               int
+		truckTypeID                                                                  int
+	)
+
+	layout := "2006-01-02 15:04:05"
+	logger := log.FromContext(ctx)
+
+	var htmlParsed bool
+	if bodyHTML != "" {
+		logger.Info("Parsing HTML body")
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(bodyHTML))
+		if err != nil {
+			logger.Error("Error parsing HTML: ", err)
+		} else {
+			orderNumber = ExtractOrderNumberFromHTML(doc)
+			pickupZip, pickupCity, pickupState, pickupStateCode, pickupCountry = ExtractLocationFromHTML(ctx, doc, "Pick Up")
+			deliveryZip, deliveryCity, deliveryState, deliveryStateCode, deliveryCountry = ExtractLocationFromHTML(ctx, doc, "Delivery")
+			pickupDateTime, _ = time.Parse(layout, FormatDateTimeString(ExtractDateTimeStringFromHTML(doc, "Pick Up")))
+			deliveryDateTime, _ = time.Parse(layout, FormatDateTimeString(ExtractDateTimeStringFromHTML(doc, "Delivery")))
+			truckSize = ExtractTruckSizeFromHTML(doc)
+			notes = ExtractNotesFromHTML(ctx, doc)
+			estimatedMiles = ExtractDistanceFromHTML(doc)
+			originalTruckSize = ExtractTruckClassFromHTML(ctx, doc)
+			length, width, height, weight, pieces, stackable, hazardous = ExtractOrderItemsFromHTML(ctx, doc)
+			pickupCountryCode = "US"
+			deliveryCountryCode = "US"
+			htmlParsed = pickupCity != "" && deliveryCity != ""
+		}
+	}
+
+	if !htmlParsed && bodyPlain != "" {
+		logger.Warn("HTML parsing failed or incomplete, falling back to plain text body")
+		orderNumber = ExtractOrderNumber(bodyPlain)
+		pickupZip, pickupCity, pickupState, pickupCountry = ExtractLocation(bodyPlain, "Pick Up")
+		deliveryZip, deliveryCity, deliveryState, deliveryCountry = ExtractLocation(bodyPlain, "Delivery")
+		pickupDateTime, _ = time.Parse(layout, FormatDateTimeString(ExtractDateTimeString(bodyPlain, "Pick Up")))
+		deliveryDateTime, _ = time.Parse(layout, FormatDateTimeString(ExtractDateTimeString(bodyPlain, "Delivery")))
+		truckSize = ExtractTruckSize(bodyPlain)
+		notes = ExtractNotes(bodyPlain)
+		length, width, height, weight, pieces, stackable, hazardous = ExtractOrderItems(bodyPlain)
+		estimatedMiles = ExtractDistance(bodyPlain)
+		pickupCountryCode = "US"
+		deliveryCountryCode = "US"
+	}
+
+	if id, exists := truckSizeToTypeID[strings.ToLower(truckSize)]; exists {
+		truckTypeID = id
+	} else {
+		truckTypeID = 4
+	}
+
+	return &ParserResult{
+		Order: models.Order{
+			OrderNumber:        orderNumber,
+			PickupDate:         pickupDateTime,
+			DeliveryDate:       deliveryDateTime,
+			SuggestedTruckSize: truckSize,
+			OriginalTruckSize:  originalTruckSize,
+			Notes:              notes,
+			TruckTypeID:        truckTypeID,
+			EstimatedMiles:     estimatedMiles,
+			OrderTypeID:        4,
+		},
+		OrderLocation: models.OrderLocation{
+			PickupCity:          pickupCity,
+			PickupState:         pickupState,
+			PickupStateCode:     pickupStateCode,
+			PickupCountryCode:   pickupCountryCode,
+			PickupCountryName:   pickupCountry,
+			PickupPostalCode:    pickupZip,
+			DeliveryCity:        deliveryCity,
+			DeliveryState:       deliveryState,
+			DeliveryStateCode:   deliveryStateCode,
+			DeliveryCountryCode: deliveryCountryCode,
+			DeliveryCountryName: deliveryCountry,
+			DeliveryPostalCode:  deliveryZip,
+			EstimatedMiles:      float64(estimatedMiles),
+		},
+		OrderItem: models.OrderItem{
+			Length:    length,
+			Width:     width,
+			Height:    height,
+			Weight:    weight,
+			Pieces:    pieces,
+			Stackable: stackable,
+			Hazardous: hazardous,
+		},
+		PickupZip:   pickupZip,
+		DeliveryZip: deliveryZip,
+		Action:      ActionNew,
+	}, nil
+}
@@ -1,43 +1,60 @@
 package parser
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"html"
-	"io"
-	"log"
 	"math"
-	"net/http"
-	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/3milly4ever/parser-landstar/internal/geocode"
+	"github.com/3milly4ever/parser-landstar/internal/log"
 	models "github.com/3milly4ever/parser-landstar/internal/model"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/sirupsen/logrus"
 )
 
+// Parser turns the body of a broker email into a ParserResult.
+// A nil result with a nil error means the load was deliberately ignored.
+type Parser interface {
+	Parse(ctx context.Context, bodyHTML, bodyPlain string) (*ParserResult, error)
+}
+
+// parsers maps the parser names used by the routing table to their implementations
+var parsers = map[string]Parser{
+	"landstar":   &LandstarParser{},
+	"fullcircle": &FullCircleParser{},
+}
+
+// ByName returns the parser registered under name
+func ByName(name string) (Parser, bool) {
+	p, ok := parsers[name]
+	return p, ok
+}
+
 type LandstarParser struct{}
 
 // ParserResult holds the parsed data
 type ParserResult struct {
-	Order         models.Order
-	OrderLocation models.OrderLocation
-	OrderItem     models.OrderItem
-	OrderEmail    models.OrderEmail
-	PickupZip     string
-	DeliveryZip   string
+	Order         models.Order         `json:"order"`
+	OrderLocation models.OrderLocation `json:"order_location"`
+	OrderItem     models.OrderItem     `json:"order_item"`
+	OrderEmail    models.OrderEmail    `json:"order_email"`
+	PickupZip     string               `json:"pickup_zip"`
+	DeliveryZip   string               `json:"delivery_zip"`
+	Action        EmailAction          `json:"action"`
 }
 
 // Parse parses the email content and returns a ParserResult
-func (p *LandstarParser) Parse(bodyHTML, bodyPlain string) (*ParserResult, error) {
+func (p *LandstarParser) Parse(ctx context.Context, bodyHTML, bodyPlain string) (*ParserResult, error) {
 	if bodyHTML == "" {
 		return nil, fmt.Errorf("bodyHTML is empty")
 	}
 
-	parserResult, err := ExtractDataFromLandstarHTML(bodyHTML)
+	parserResult, err := ExtractDataFromLandstarHTML(ctx, bodyHTML)
 	if err != nil {
 		return nil, err
 	}
@@ -45,11 +62,12 @@ func (p *LandstarParser) Parse(bodyHTML, bodyPlain string) (*ParserResult, error
 	return parserResult, nil
 }
 
-func ExtractDataFromLandstarHTML(bodyHTML string) (*ParserResult, error) {
+func ExtractDataFromLandstarHTML(ctx context.Context, bodyHTML string) (*ParserResult, error) {
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(bodyHTML))
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse HTML: %v", err)
 	}
+	logger := log.FromContext(ctx)
 
 	// Initialize models
 	order := models.Order{
@@ -67,16 +85,16 @@ func ExtractDataFromLandstarHTML(bodyHTML string) (*ParserResult, error) {
 
 	// Extract OrderNumber
 	order.OrderNumber = ExtractOrderNumberFromLandstarHTML(doc)
-	logrus.Infof("Extracted Order Number: %s", order.OrderNumber)
+	logger.Infof("Extracted Order Number: %s", order.OrderNumber)
 
 	// Extract Trailer Type (SuggestedTruckSize)
 	order.SuggestedTruckSize = ExtractTrailerTypeFromLandstarHTML(doc)
 	order.OriginalTruckSize = order.SuggestedTruckSize
-	logrus.Infof("Extracted Suggested Truck Size: %s", order.SuggestedTruckSize)
+	logger.Infof("Extracted Suggested Truck Size: %s", order.SuggestedTruckSize)
 
 	// **Check if originalTruckSize contains "FLAT" or "REF" and ignore it if true**
 	if strings.Contains(strings.ToUpper(order.OriginalTruckSize), "FLAT") || strings.Contains(strings.ToUpper(order.OriginalTruckSize), "REF") {
-		logrus.Warnf("Ignoring Original Truck Size as it contains 'FLAT' or 'REF'. OriginalTruckSize: %s", order.OriginalTruckSize)
+		logger.Warnf("Ignoring Original Truck Size as it contains 'FLAT' or 'REF'. OriginalTruckSize: %s", order.OriginalTruckSize)
 		order.OriginalTruckSize = "" // Set it to empty string or handle it as per your logic
 		return nil, nil              // Return nil without parsing or saving
 	}
@@ -84,10 +102,10 @@ func ExtractDataFromLandstarHTML(bodyHTML string) (*ParserResult, error) {
 	// Extract EstimatedMiles
 	order.EstimatedMiles = ExtractMilesFromLandstarHTML(doc)
 	orderLocation.EstimatedMiles = float64(order.EstimatedMiles)
-	logrus.Infof("Extracted Estimated Miles: %d", order.EstimatedMiles)
+	logger.Infof("Extracted Estimated Miles: %d", order.EstimatedMiles)
 
 	// Extract Origin and Destination from Stops
-	origin, destination := ExtractStopsFromLandstarHTML(doc)
+	origin, destination := ExtractStopsFromLandstarHTML(ctx, doc)
 	// No need to assign or log origin and destination here since we construct the locations later
 
 	// Extract City, State, StateCode, and Zip from Origin and Destination
@@ -113,53 +131,53 @@ func ExtractDataFromLandstarHTML(bodyHTML string) (*ParserResult, error) {
 	pickupDate, err := ExtractPickupDateFromLandstarHTML(doc)
 	if err == nil {
 		order.PickupDate = pickupDate
-		logrus.Infof("Extracted Pickup Date: %s", order.PickupDate)
+		logger.Infof("Extracted Pickup Date: %s", order.PickupDate)
 	} else {
-		logrus.Warnf("Failed to parse Pickup Date: %v", err)
+		logger.Warnf("Failed to parse Pickup Date: %v", err)
 	}
 
 	// Extract DeliveryDate
 	deliveryDate, err := ExtractDeliveryDateFromLandstarHTML(doc)
 	if err == nil {
 		order.DeliveryDate = deliveryDate
-		logrus.Infof("Extracted Delivery Date: %s", order.DeliveryDate)
+		logger.Infof("Extracted Delivery Date: %s", order.DeliveryDate)
 	} else {
-		logrus.Warnf("Failed to parse Delivery Date: %v", err)
+		logger.Warnf("Failed to parse Delivery Date: %v", err)
 	}
 
 	// Extract Notes from Comments
 	order.Notes = ExtractNotesFromLandstarHTML(doc)
-	logrus.Infof("Extracted Notes: %s", order.Notes)
+	logger.Infof("Extracted Notes: %s", order.Notes)
 
 	// Extract Commodity details (OrderItem)
-	length, width, height, weight, hazardous := ExtractCommodityFromLandstarHTML(doc)
+	length, width, height, weight, hazardous := ExtractCommodityFromLandstarHTML(ctx, doc)
 	orderItem.Length = length
 	orderItem.Width = width
 	orderItem.Height = height
 	orderItem.Weight = weight
 	orderItem.Hazardous = hazardous
-	logrus.Infof("Extracted Commodity - Length: %.0f, Width: %.0f, Height: %.0f, Weight: %.0f, Hazardous: %t", length, width, height, weight, hazardous)
+	logger.Infof("Extracted Commodity - Length: %.0f, Width: %.0f, Height: %.0f, Weight: %.0f, Hazardous: %t", length, width, height, weight, hazardous)
 
 	if orderItem.Length == 0.0 {
 		// Length is zero, need to extract from OriginalTruckSize
 		originalTruckSize := strings.ToUpper(strings.TrimSpace(order.OriginalTruckSize))
 		re := regexp.MustCompile(`\d+`)
 		numberStr := re.FindString(originalTruckSize)
-		logrus.Infof("OriginalTruckSize: %s", originalTruckSize)
+		logger.Infof("OriginalTruckSize: %s", originalTruckSize)
 		if numberStr != "" {
-			logrus.Infof("Extracted number string from OriginalTruckSize: %s", numberStr)
+			logger.Infof("Extracted number string from OriginalTruckSize: %s", numberStr)
 			number, err := strconv.Atoi(numberStr)
 			if err != nil {
-				logrus.Errorf("Failed to convert extracted number to int: %v", err)
+				logger.Errorf("Failed to convert extracted number to int: %v", err)
 				return nil, nil // Return nil without parsing or saving
 			} else {
-				logrus.Infof("Extracted number from OriginalTruckSize: %d", number)
+				logger.Infof("Extracted number from OriginalTruckSize: %d", number)
 				// Use the number to set orderItem.Length
 				orderItem.Length = float64(number)
 			}
 		} else {
 			// No number found in OriginalTruckSize
-			logrus.Warnf("No numeric value found in OriginalTruckSize: %s", originalTruckSize)
+			logger.Warnf("No numeric value found in OriginalTruckSize: %s", originalTruckSize)
 			return nil, nil // Return nil without parsing or saving
 		}
 	}
@@ -177,13 +195,13 @@ func ExtractDataFromLandstarHTML(bodyHTML string) (*ParserResult, error) {
 		order.SuggestedTruckSize = "Large Straight"
 		order.TruckTypeID = 2
 	} else {
-		logrus.Warnf("TRUCK LENGTH TOO LONG %v", orderItem.Length)
+		logger.Warnf("TRUCK LENGTH TOO LONG %v", orderItem.Length)
 		return nil, nil // Return nil without parsing or saving
 	}
 
-	logrus.Infof("Adjusted Suggested Truck Size: %s", order.SuggestedTruckSize)
-	logrus.Infof("Set TruckTypeID: %d", order.TruckTypeID)
-	logrus.Infof("Set OrderTypeID: %d", order.OrderTypeID)
+	logger.Infof("Adjusted Suggested Truck Size: %s", order.SuggestedTruckSize)
+	logger.Infof("Set TruckTypeID: %d", order.TruckTypeID)
+	logger.Infof("Set OrderTypeID: %d", order.OrderTypeID)
 
 	// Pieces and Stackable are not specified; set default values
 	orderItem.Pieces = 1
@@ -196,26 +214,27 @@ func ExtractDataFromLandstarHTML(bodyHTML string) (*ParserResult, error) {
 		OrderItem:     orderItem,
 		PickupZip:     pickupZip,
 		DeliveryZip:   deliveryZip,
+		Action:        ActionNew,
 	}
 
 	// Check and fill missing zip codes
 	if parserResult.PickupZip == "" {
-		pickupZip, err := GetZipCode(orderLocation.PickupCity, orderLocation.PickupState)
+		pickupZip, err := GetZipCode(ctx, orderLocation.PickupCity, orderLocation.PickupState)
 		if err != nil {
-			logrus.Warnf("Failed to get pickup zip code: %v", err)
+			logger.Warnf("Failed to get pickup zip code: %v", err)
 		} else {
 			parserResult.PickupZip = pickupZip
-			logrus.Infof("Retrieved Pickup Zip Code: %s", pickupZip)
+			logger.Infof("Retrieved Pickup Zip Code: %s", pickupZip)
 		}
 	}
 
 	if parserResult.DeliveryZip == "" {
-		deliveryZip, err := GetZipCode(orderLocation.DeliveryCity, orderLocation.DeliveryState)
+		deliveryZip, err := GetZipCode(ctx, orderLocation.DeliveryCity, orderLocation.DeliveryState)
 		if err != nil {
-			logrus.Warnf("Failed to get delivery zip code: %v", err)
+			logger.Warnf("Failed to get delivery zip code: %v", err)
 		} else {
 			parserResult.DeliveryZip = deliveryZip
-			logrus.Infof("Retrieved Delivery Zip Code: %s", deliveryZip)
+			logger.Infof("Retrieved Delivery Zip Code: %s", deliveryZip)
 		}
 	}
 
@@ -229,17 +248,17 @@ func ExtractDataFromLandstarHTML(bodyHTML string) (*ParserResult, error) {
 	}
 
 	// Proceed with building locations
-	pickupLocation := buildLocation(parserResult.PickupZip, orderLocation.PickupCity, orderLocation.PickupState, orderLocation.PickupCountryName)
-	deliveryLocation := buildLocation(parserResult.DeliveryZip, orderLocation.DeliveryCity, orderLocation.DeliveryState, orderLocation.DeliveryCountryName)
+	pickupLocation := buildLocation(ctx, parserResult.PickupZip, orderLocation.PickupCity, orderLocation.PickupState, orderLocation.PickupCountryName)
+	deliveryLocation := buildLocation(ctx, parserResult.DeliveryZip, orderLocation.DeliveryCity, orderLocation.DeliveryState, orderLocation.DeliveryCountryName)
 
 	// Assign the constructed locations
 	order.PickupLocation = pickupLocation
 	orderLocation.PickupLabel = pickupLocation
-	logrus.Infof("Constructed Pickup Location: %s", pickupLocation)
+	logger.Infof("Constructed Pickup Location: %s", pickupLocation)
 
 	order.DeliveryLocation = deliveryLocation
 	orderLocation.DeliveryLabel = deliveryLocation
-	logrus.Infof("Constructed Delivery Location: %s", deliveryLocation)
+	logger.Infof("Constructed Delivery Location: %s", deliveryLocation)
 
 	return parserResult, nil
 }
@@ -340,7 +359,7 @@ func ExtractOrderNumberFromLandstarHTML(doc *goquery.Document) string {
 	return GetValueAfterLabel(doc, "Load #")
 }
 
-func ExtractStopsFromLandstarHTML(doc *goquery.Document) (origin, destination string) {
+func ExtractStopsFromLandstarHTML(ctx context.Context, doc *goquery.Document) (origin, destination string) {
 	// Find the table with id="stopsDiv"
 	doc.Find("div#stopsDiv").Each(func(i int, s *goquery.Selection) {
 		s.Find("tr").Each(func(i int, tr *goquery.Selection) {
@@ -353,7 +372,7 @@ func ExtractStopsFromLandstarHTML(doc *goquery.Document) (origin, destination st
 			cityStateTd := tr.Find("td").Eq(1)
 			cityState := strings.TrimSpace(cityStateTd.Text())
 
-			logrus.Infof("Found stopType: %s, cityState: %s", stopType, cityState)
+			log.FromContext(ctx).Infof("Found stopType: %s, cityState: %s", stopType, cityState)
 
 			if stopType == "Origin" {
 				origin = cityState
@@ -415,7 +434,7 @@ func ExtractNotesFromLandstarHTML(doc *goquery.Document) string {
 	return notes
 }
 
-func ExtractCommodityFromLandstarHTML(doc *goquery.Document) (length, width, height, weight float64, hazardous bool) {
+func ExtractCommodityFromLandstarHTML(ctx context.Context, doc *goquery.Document) (length, width, height, weight float64, hazardous bool) {
 	// Locate the commodity table
 	doc.Find("div#commodityDiv table").Each(func(i int, s *goquery.Selection) {
 		s.Find("tr").Each(func(j int, tr *goquery.Selection) {
@@ -428,13 +447,13 @@ func ExtractCommodityFromLandstarHTML(doc *goquery.Document) (length, width, hei
 				text := td.Text()
 				switch k {
 				case 2: // Length
-					length = parseDimension(text)
+					length = parseDimension(ctx, text)
 				case 3: // Width
-					width = parseDimension(text)
+					width = parseDimension(ctx, text)
 				case 4: // Height
-					height = parseDimension(text)
+					height = parseDimension(ctx, text)
 				case 5: // Weight
-					weight = parseWeight(text)
+					weight = parseWeight(ctx, text)
 				case 6: // Hazardous
 					hazardous = strings.TrimSpace(text) == "Y"
 				}
@@ -477,9 +496,9 @@ func parseFeetInches(text string) float64 {
 	return 0.0
 }
 
-func parseWeight(weightText string) float64 {
+func parseWeight(ctx context.Context, weightText string) float64 {
 
-	logrus.Infof("Raw weight text: %s", weightText)
+	log.FromContext(ctx).Infof("Raw weight text: %s", weightText)
 	// Trim whitespace and unescape HTML entities
 	weightText = strings.TrimSpace(html.UnescapeString(weightText))
 
@@ -501,7 +520,7 @@ func parseWeight(weightText string) float64 {
 
 	weightValue, err := strconv.ParseFloat(weightText, 64)
 	if err != nil {
-		logrus.Errorf("Error parsing weight: %v", err)
+		log.FromContext(ctx).Errorf("Error parsing weight: %v", err)
 		return 0.0
 	}
 	return weightValue
@@ -613,7 +632,7 @@ var stateNameToCode = map[string]string{
 
 // ExtractLocationFromHTML extracts the location details (zip, city, state, country) from the HTML
 // Now it will return both the state and stateCode
-func ExtractLocationFromHTML(doc *goquery.Document, event string) (string, string, string, string, string) {
+func ExtractLocationFromHTML(ctx context.Context, doc *goquery.Document, event string) (string, string, string, string, string) {
 	var zip, city, state, stateCode, country string
 
 	// Find the correct table row based on the event name (Pick Up or Delivery)
@@ -636,7 +655,7 @@ func ExtractLocationFromHTML(doc *goquery.Document, event string) (string, strin
 		}
 	})
 
-	logrus.WithFields(logrus.Fields{
+	log.FromContext(ctx).WithFields(logrus.Fields{
 		"event":     event,
 		"city":      city,
 		"state":     state,
@@ -691,7 +710,7 @@ func ExtractTruckSizeFromHTML(doc *goquery.Document) string {
 }
 
 // ExtractOrderItemsFromHTML extracts the order items (dimensions, weight, etc.) from the HTML body.
-func ExtractOrderItemsFromHTML(doc *goquery.Document) (length, width, height, weight float64, pieces int, stackable, hazardous bool) {
+func ExtractOrderItemsFromHTML(ctx context.Context, doc *goquery.Document) (length, width, height, weight float64, pieces int, stackable, hazardous bool) {
 	// Extract dimensions from the table following the "Dimensions" paragraph
 	dimensionsParagraph := doc.Find("p:contains('Dimensions')")
 	dimensionsTable := dimensionsParagraph.NextFiltered("table")
@@ -705,7 +724,7 @@ func ExtractOrderItemsFromHTML(doc *goquery.Document) (length, width, height, we
 			stackableStr := s.Find("td").Eq(3).Text()
 
 			// Log extracted values for debugging
-			logrus.Infof("Extracted Length: %s, Width: %s, Height: %s, Stackable: %s", lengthStr, widthStr, heightStr, stackableStr)
+			log.FromContext(ctx).Infof("Extracted Length: %s, Width: %s, Height: %s, Stackable: %s", lengthStr, widthStr, heightStr, stackableStr)
 
 			// Parse the extracted dimensions, removing units like " in"
 			length = parseFloatFromText(strings.TrimSpace(lengthStr))
@@ -733,7 +752,7 @@ func ExtractOrderItemsFromHTML(doc *goquery.Document) (length, width, height, we
 
 	// Extract hazardous info
 	hazardousText := doc.Find("p:contains('Hazardous?')").Text()
-	logrus.Infof("Extracted Hazardous Text: %s", hazardousText)
+	log.FromContext(ctx).Infof("Extracted Hazardous Text: %s", hazardousText)
 
 	// Split the text into lines to isolate the "Hazardous? : Yes/No" line
 	lines := strings.Split(hazardousText, "\n")
@@ -744,7 +763,7 @@ func ExtractOrderItemsFromHTML(doc *goquery.Document) (length, width, height, we
 			parts := strings.SplitN(line, ":", 2)
 			if len(parts) == 2 {
 				hazardousValue := strings.TrimSpace(strings.ToLower(parts[1]))
-				logrus.Infof("Extracted Hazardous Value: %s", hazardousValue)
+				log.FromContext(ctx).Infof("Extracted Hazardous Value: %s", hazardousValue)
 				if hazardousValue == "yes" {
 					hazardous = true
 				} else if hazardousValue == "no" {
@@ -763,7 +782,7 @@ func ExtractOrderItemsFromHTML(doc *goquery.Document) (length, width, height, we
 	return length, width, height, weight, pieces, stackable, hazardous
 }
 
-func buildLocation(addressLine, city, state, countryName string) string {
+func buildLocation(ctx context.Context, addressLine, city, state, countryName string) string {
 	// Function to clean a string
 	cleanString := func(s string) string {
 		s = strings.TrimSpace(s)
@@ -781,7 +800,7 @@ func buildLocation(addressLine, city, state, countryName string) string {
 	countryName = cleanString(countryName)
 
 	// Log the components after cleaning
-	logrus.Infof("Components after cleaning - addressLine: '%s', city: '%s', state: '%s', countryName: '%s'",
+	log.FromContext(ctx).Infof("Components after cleaning - addressLine: '%s', city: '%s', state: '%s', countryName: '%s'",
 		addressLine, city, state, countryName)
 
 	// Only add components that are not empty
@@ -802,7 +821,7 @@ func buildLocation(addressLine, city, state, countryName string) string {
 	return strings.Join(parts, ", ")
 }
 
-func parseDimension(dimensionText string) float64 {
+func parseDimension(ctx context.Context, dimensionText string) float64 {
 	// Unescape HTML entities and initial cleaning
 	dimensionText = html.UnescapeString(dimensionText)
 	dimensionText = strings.ReplaceAll(dimensionText, "\u00a0", " ")
@@ -817,7 +836,7 @@ func parseDimension(dimensionText string) float64 {
 	dimensionText = strings.ReplaceAll(dimensionText, "\"", "")
 
 	// Log the cleaned raw dimension text
-	logrus.Infof("Raw dimension text: %s", dimensionText)
+	log.FromContext(ctx).Infof("Raw dimension text: %s", dimensionText)
 
 	if dimensionText == "" || dimensionText == "0" {
 		return 0.0
@@ -828,7 +847,7 @@ func parseDimension(dimensionText string) float64 {
 	matches := re.FindStringSubmatch(dimensionText)
 
 	if matches == nil {
-		logrus.Errorf("Dimension text '%s' does not match expected format", dimensionText)
+		log.FromContext(ctx).Errorf("Dimension text '%s' does not match expected format", dimensionText)
 		return 0.0
 	}
 
@@ -847,7 +866,7 @@ func parseDimension(dimensionText string) float64 {
 	totalFeet = math.Round(totalFeet*100) / 100
 
 	// Log the parsed dimension without 'feet' unit
-	logrus.Infof("Parsed dimension: %.2f", totalFeet)
+	log.FromContext(ctx).Infof("Parsed dimension: %.2f", totalFeet)
 
 	return totalFeet
 }
@@ -968,7 +987,7 @@ func ExtractReplyToFromHTML(doc *goquery.Document) string {
 var emailRegex = regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`)
 
 // ExtractReplyTo looks for the phrase "reply to" and extracts the email address after it.
-func ExtractReplyTo(body string) string {
+func ExtractReplyTo(ctx context.Context, body string) string {
 	// Log the body to debug what's inside it
 	//log.Printf("Body content before searching for reply-to:\n%s\n", body)
 
@@ -984,7 +1003,7 @@ func ExtractReplyTo(body string) string {
 		afterKeyword := body[keywordIndex+len(keyword):]
 
 		// Log the text after "reply to" for debugging
-		log.Printf("Text after 'reply to': %s\n", afterKeyword)
+		log.FromContext(ctx).Debugf("Text after 'reply to': %s", afterKeyword)
 
 		// Use the email regex to find the email in the extracted portion
 		matches := emailRegex.FindString(afterKeyword)
@@ -1037,7 +1056,7 @@ func parseInt(value string) int {
 }
 
 // ExtractTruckClassFromHTML extracts the truck class (e.g., Small Straight, Large Straight, Tractor Trailer) from the HTML document.
-func ExtractTruckClassFromHTML(doc *goquery.Document) string {
+func ExtractTruckClassFromHTML(ctx context.Context, doc *goquery.Document) string {
 	var truckClass string
 
 	// Look for the <p> tag that contains "Requested Vehicle Class" and extract the text after the colon.
@@ -1053,7 +1072,7 @@ func ExtractTruckClassFromHTML(doc *goquery.Document) string {
 				truckClass = strings.Split(truckClass, "\n")[0]
 				truckClass = strings.TrimSpace(truckClass) // Ensure no extra spaces
 			}
-			logrus.Infof("Extracted Truck Class: %s", truckClass)
+			log.FromContext(ctx).Infof("Extracted Truck Class: %s", truckClass)
 		}
 	})
 
@@ -1065,7 +1084,7 @@ func ExtractTruckClassFromHTML(doc *goquery.Document) string {
 }
 
 // ExtractNotesFromHTML extracts the notes from the HTML body
-func ExtractNotesFromHTML(doc *goquery.Document) (notes string) {
+func ExtractNotesFromHTML(ctx context.Context, doc *goquery.Document) (notes string) {
 	// Find the <p> or any tag containing "Notes:" in the text
 	doc.Find("p, h4").Each(func(i int, s *goquery.Selection) {
 		text := s.Text()
@@ -1074,76 +1093,30 @@ func ExtractNotesFromHTML(doc *goquery.Document) (notes string) {
 			notes = strings.TrimSpace(strings.SplitAfter(text, "Notes:")[1])
 
 			// Log the extracted notes for debugging
-			logrus.Infof("Extracted Notes: %s", notes)
+			log.FromContext(ctx).Infof("Extracted Notes: %s", notes)
 		}
 	})
 
 	return notes
 }
 
-func GetZipCode(city, state string) (string, error) {
-	// Prepare the base URL and query parameters
-	baseURL := "http://207.244.250.222:4000/v1/search"
-	params := url.Values{}
-	// Construct the text parameter with city and state
-	query := fmt.Sprintf("%s, %s", city, state)
-	params.Add("text", query)
-	params.Add("size", "1") // Limit to the best match
-
-	// Construct the full URL
-	fullURL := fmt.Sprintf("%s?%s", baseURL, params.Encode())
-
-	// Make the HTTP request
-	resp, err := http.Get(fullURL)
+// GetZipCode looks up the postal code of a city with the configured geocoder
+func GetZipCode(ctx context.Context, city, state string) (string, error) {
+	geocoder, err := geocode.Default()
 	if err != nil {
-		logrus.WithField("url", fullURL).Error("Failed to make geocoding request: ", err)
-		return "", fmt.Errorf("failed to make geocoding request: %w", err)
+		return "", fmt.Errorf("failed to create geocoder: %w", err)
 	}
-	defer resp.Body.Close()
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logrus.WithField("url", fullURL).Error("Failed to read geocoding response body: ", err)
-		return "", fmt.Errorf("failed to read geocoding response body: %w", err)
-	}
-
-	// Unmarshal the response into a generic interface
-	var result map[string]interface{}
-	err = json.Unmarshal(body, &result)
+	query := fmt.Sprintf("%s, %s", city, state)
+	result, err := geocoder.Geocode(ctx, query)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"url":  fullURL,
-			"body": string(body),
-		}).Error("Failed to unmarshal geocoding response: ", err)
-		return "", fmt.Errorf("failed to unmarshal geocoding response: %w", err)
+		log.FromContext(ctx).WithField("query", query).Error("Failed to geocode city: ", err)
+		return "", err
 	}
 
-	// Print the full URL in case of error
-	logrus.WithField("url", fullURL).Info("Geocoding URL sent for address")
-
-	// Check if there are any features in the response
-	features, ok := result["features"].([]interface{})
-	if !ok || len(features) == 0 {
-		logrus.WithField("url", fullURL).Error("No geocoding features found in the response")
-		return "", fmt.Errorf("no geocoding features found in the response")
+	if result.PostalCode == "" {
+		log.FromContext(ctx).WithField("query", query).Warn("Postal code not found in geocoding result")
+		return "", fmt.Errorf("postal code not found in geocoding result")
 	}
-
-	// Extract the first feature's properties
-	firstFeature := features[0].(map[string]interface{})
-	properties, ok := firstFeature["properties"].(map[string]interface{})
-	if !ok {
-		logrus.Warn("Properties field is missing in geocoding response")
-		return "", fmt.Errorf("properties field is missing in geocoding response")
-	}
-
-	// Extract the postal code
-	postalCode, ok := properties["postalcode"].(string)
-	if !ok || postalCode == "" {
-		logrus.Warn("Postal code not found in properties")
-		return "", fmt.Errorf("postal code not found in properties")
-	}
-
-	// Return the postal code
-	return postalCode, nil
+	return result.PostalCode, nil
 }
@@ -1,19 +1,54 @@
 package routes
 
 import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/3milly4ever/parser-landstar/internal/fieldstats"
 	"github.com/3milly4ever/parser-landstar/internal/handler"
+	"github.com/3milly4ever/parser-landstar/internal/health"
+	"github.com/3milly4ever/parser-landstar/internal/log"
+	"github.com/3milly4ever/parser-landstar/internal/middleware"
+	"github.com/3milly4ever/parser-landstar/internal/outbox"
+	"github.com/3milly4ever/parser-landstar/internal/webhook"
+	config "github.com/3milly4ever/parser-landstar/pkg"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/gofiber/fiber/v2"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 func Setup(app *fiber.App) {
+	// Outbox and webhook management expose order payloads and can send them anywhere,
+	// they need ADMIN_TOKEN
+	admin := middleware.AdminAuth()
+
 	// Root route
 	app.Get("/", func(c *fiber.Ctx) error {
-		logrus.Info("Root route accessed")
+		log.FromContext(c.UserContext()).Info("Root route accessed")
 		return c.SendString("Welcome to the Fiber server!")
 	})
 
+	// Liveness, the process is up and serving requests. Dependencies are left to /readyz
+	// so an outage elsewhere does not get the container restarted.
+	app.Get("/healthz", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"status": health.StatusOK})
+	})
+
+	// Readiness, every dependency answered within HEALTH_CHECK_TIMEOUT. 503 takes the
+	// instance out of the load balancer until the failing dependency is back.
+	app.Get("/readyz", func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+		report := health.Run(ctx, config.AppConfig.HealthCheckTimeout, readinessChecks()...)
+		if !report.Ready() {
+			log.FromContext(ctx).WithField("checks", report.Checks).Warn("Readiness check failed")
+			return c.Status(fiber.StatusServiceUnavailable).JSON(report)
+		}
+		return c.JSON(report)
+	})
+
 	// Mailgun route
 	app.Post("/mailgun", func(c *fiber.Ctx) error {
 		ctx := c.Context()
@@ -27,4 +62,178 @@ func Setup(app *fiber.App) {
 		}
 		return c.Status(response.StatusCode).SendString(response.Body)
 	})
+
+	// Parse preview route, runs detection and parsing without writing to MySQL or SQS.
+	// Accepts the same form fields as /mailgun, or a raw RFC 822 email.
+	app.Post("/parse/preview", func(c *fiber.Ctx) error {
+		var email handler.Email
+		if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationForm) {
+			formData, err := url.ParseQuery(string(c.Body()))
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid form data"})
+			}
+			email = handler.EmailFromForm(formData)
+		} else {
+			var err error
+			email, err = handler.EmailFromRaw(c.Body())
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+
+		if email.BodyHTML == "" && email.BodyPlain == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "email has no body"})
+		}
+
+		ctx := log.WithField(c.UserContext(), log.FieldMessageID, email.MessageID)
+		log.FromContext(ctx).WithField("subject", email.Subject).Info("Parse preview requested")
+		response := handler.Preview(ctx, email)
+		if response.Error != "" {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(response)
+		}
+		return c.JSON(response)
+	})
+
+	// Outbox state, message counts per kind and status, oldest pending message and recent dead ones
+	app.Get("/outbox", admin, func(c *fiber.Ctx) error {
+		db, err := handler.InitializeDB()
+		if err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "database unavailable"})
+		}
+		ctx := c.UserContext()
+		state, err := outbox.GetState(ctx, db)
+		if err != nil {
+			log.FromContext(ctx).Error("Failed to read outbox state: ", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to read outbox state"})
+		}
+		return c.JSON(state)
+	})
+
+	// Field fill rates per parser over the last ?hours (default 24), next to the ?baseline_days before (default 7)
+	app.Get("/stats/fill-rates", func(c *fiber.Ctx) error {
+		db, err := handler.InitializeDB()
+		if err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "database unavailable"})
+		}
+		window := time.Duration(c.QueryInt("hours", 24)) * time.Hour
+		baseline := time.Duration(c.QueryInt("baseline_days", 7)) * 24 * time.Hour
+		ctx := c.UserContext()
+		summary, err := fieldstats.Summary(ctx, db, window, baseline)
+		if err != nil {
+			log.FromContext(ctx).Error("Failed to read field fill rates: ", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to read field fill rates"})
+		}
+		if name := c.Query("parser"); name != "" {
+			filtered := summary[:0]
+			for _, rate := range summary {
+				if rate.Parser == name {
+					filtered = append(filtered, rate)
+				}
+			}
+			summary = filtered
+		}
+		return c.JSON(summary)
+	})
+
+	// Webhook subscriptions
+	app.Get("/webhooks", admin, func(c *fiber.Ctx) error {
+		db, err := handler.InitializeDB()
+		if err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "database unavailable"})
+		}
+		ctx := c.UserContext()
+		subscriptions, err := webhook.Subscriptions(ctx, db)
+		if err != nil {
+			log.FromContext(ctx).Error("Failed to list webhook subscriptions: ", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to list webhook subscriptions"})
+		}
+		return c.JSON(subscriptions)
+	})
+
+	app.Post("/webhooks", admin, func(c *fiber.Ctx) error {
+		var request webhook.NewSubscription
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid subscription"})
+		}
+		db, err := handler.InitializeDB()
+		if err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "database unavailable"})
+		}
+		ctx := c.UserContext()
+		subscription, err := webhook.Subscribe(ctx, db, request)
+		if errors.Is(err, webhook.ErrInvalidSubscription) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			log.FromContext(ctx).Error("Failed to create webhook subscription: ", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create webhook subscription"})
+		}
+		log.FromContext(ctx).WithFields(logrus.Fields{"subscription_id": subscription.ID, "url": subscription.URL}).Info("Webhook subscription created")
+		return c.Status(fiber.StatusCreated).JSON(subscription)
+	})
+
+	app.Delete("/webhooks/:id", admin, func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+		}
+		db, err := handler.InitializeDB()
+		if err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "database unavailable"})
+		}
+		ctx := log.WithField(c.UserContext(), "subscription_id", id)
+		err = webhook.Unsubscribe(ctx, db, id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "subscription not found"})
+		}
+		if err != nil {
+			log.FromContext(ctx).Error("Failed to remove webhook subscription: ", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to remove webhook subscription"})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	app.Get("/webhooks/:id/deliveries", admin, func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+		}
+		db, err := handler.InitializeDB()
+		if err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "database unavailable"})
+		}
+		ctx := log.WithField(c.UserContext(), "subscription_id", id)
+		deliveries, err := webhook.Deliveries(ctx, db, id, c.QueryInt("limit", 50))
+		if err != nil {
+			log.FromContext(ctx).Error("Failed to list webhook deliveries: ", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to list webhook deliveries"})
+		}
+		return c.JSON(deliveries)
+	})
+}
+
+// readinessChecks lists the dependencies an email needs on its way to an order
+func readinessChecks() []health.Check {
+	geocoderURL := config.AppConfig.GeocoderURL
+	if config.AppConfig.GeocoderProvider == "static" {
+		// Lookups are served from a file
+		geocoderURL = ""
+	}
+
+	return []health.Check{
+		health.MySQL(handler.InitializeDB),
+		health.SQSQueue(config.AppConfig.SQSQueueURL),
+		health.HTTP("geocoder", geocoderURL),
+		// Only the host is probed, the send_order endpoint itself must not be called without an order
+		health.HTTP("platform", origin(config.AppConfig.PlatformSendOrderURL)),
+	}
+}
+
+// origin returns the scheme and host of a URL, or "" when it cannot be parsed
+func origin(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return ""
+	}
+	return parsed.Scheme + "://" + parsed.Host + "/"
 }
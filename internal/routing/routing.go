@@ -0,0 +1,217 @@
+// Package routing picks the parser for an inbound email based on its envelope and contents.
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/3milly4ever/parser-landstar/internal/parser"
+	config "github.com/3milly4ever/parser-landstar/pkg"
+)
+
+// QuarantineRoute is the DEFAULT_ROUTE value that holds unknown senders instead of parsing them
+const QuarantineRoute = "quarantine"
+
+// Envelope is the part of an email routing looks at
+type Envelope struct {
+	Recipient string
+	From      string
+	Subject   string
+	BodyHTML  string
+	BodyPlain string
+}
+
+// Route sends matching emails to a parser.
+// Every condition that is set must match; empty conditions match anything.
+type Route struct {
+	Name string `json:"name"`
+
+	// Recipient is an envelope recipient, e.g. "landstar@our-domain.com".
+	// Shell style wildcards are allowed, e.g. "*@our-domain.com".
+	Recipient string `json:"recipient,omitempty"`
+	// FromDomain matches the sender's domain and its subdomains
+	FromDomain string `json:"from_domain,omitempty"`
+	// SubjectPattern is a regular expression matched against the subject
+	SubjectPattern string `json:"subject_pattern,omitempty"`
+	// BodyContains must appear in the HTML or plain text body
+	BodyContains string `json:"body_contains,omitempty"`
+
+	Parser      string `json:"parser"`
+	ParserID    uint64 `json:"parser_id"`
+	OrderTypeID int    `json:"order_type_id"`
+
+	subjectRegex *regexp.Regexp
+}
+
+// Table is an ordered list of routes, the first match wins
+type Table struct {
+	Routes []Route `json:"routes"`
+	// Default is the route name used when nothing matches, or "quarantine".
+	// The default route is matched like any other route first.
+	Default string `json:"default"`
+}
+
+// DefaultTable reproduces the routing we had before routes were configurable
+func DefaultTable() *Table {
+	return &Table{
+		Routes: []Route{
+			{
+				Name:         "landstar",
+				BodyContains: "www.LandstarCarriers.com/Loads",
+				Parser:       "landstar",
+				ParserID:     4,
+				OrderTypeID:  5,
+			},
+			{
+				// The FullCircle parser reads the "Pick Up" stop row of both bodies
+				Name:         "fullcircle",
+				BodyContains: "Pick Up",
+				Parser:       "fullcircle",
+				ParserID:     4,
+				OrderTypeID:  4,
+			},
+		},
+		Default: "fullcircle",
+	}
+}
+
+// Load reads the table from ROUTES_FILE, or uses the built in table when it is not set.
+// DEFAULT_ROUTE overrides the table's default.
+func Load() (*Table, error) {
+	table := DefaultTable()
+
+	if file := config.AppConfig.RoutesFile; file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read routes file: %w", err)
+		}
+		table = &Table{}
+		if err := json.Unmarshal(data, table); err != nil {
+			return nil, fmt.Errorf("failed to parse routes file: %w", err)
+		}
+	}
+
+	if config.AppConfig.DefaultRoute != "" {
+		table.Default = config.AppConfig.DefaultRoute
+	}
+
+	if err := table.compile(); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// compile validates the routes and prepares their subject patterns
+func (t *Table) compile() error {
+	names := map[string]bool{}
+	for i := range t.Routes {
+		route := &t.Routes[i]
+		if route.Name == "" || route.Parser == "" {
+			return fmt.Errorf("route %d needs a name and a parser", i)
+		}
+		if _, ok := parser.ByName(route.Parser); !ok {
+			return fmt.Errorf("route %s uses unknown parser %q", route.Name, route.Parser)
+		}
+		names[route.Name] = true
+
+		if route.SubjectPattern != "" {
+			re, err := regexp.Compile(route.SubjectPattern)
+			if err != nil {
+				return fmt.Errorf("route %s has an invalid subject pattern: %w", route.Name, err)
+			}
+			route.subjectRegex = re
+		}
+	}
+
+	if t.Default == "" {
+		t.Default = QuarantineRoute
+	}
+	if t.Default != QuarantineRoute && !names[t.Default] {
+		return fmt.Errorf("default route %q does not exist", t.Default)
+	}
+	return nil
+}
+
+// Match returns the route for an email, falling back to the default route when no route
+// matches. A nil route means the email should be quarantined.
+func (t *Table) Match(envelope Envelope) *Route {
+	for i := range t.Routes {
+		if t.Routes[i].matches(envelope) {
+			return &t.Routes[i]
+		}
+	}
+
+	for i := range t.Routes {
+		if t.Routes[i].Name == t.Default {
+			return &t.Routes[i]
+		}
+	}
+	return nil
+}
+
+func (r *Route) matches(envelope Envelope) bool {
+	if r.Recipient != "" && !matchRecipient(r.Recipient, envelope.Recipient) {
+		return false
+	}
+	if r.FromDomain != "" && !matchDomain(r.FromDomain, envelope.From) {
+		return false
+	}
+	if r.subjectRegex != nil && !r.subjectRegex.MatchString(envelope.Subject) {
+		return false
+	}
+	if r.BodyContains != "" && !strings.Contains(envelope.BodyHTML, r.BodyContains) && !strings.Contains(envelope.BodyPlain, r.BodyContains) {
+		return false
+	}
+	return true
+}
+
+// matchRecipient checks every address of a possibly comma separated recipient list
+func matchRecipient(pattern, recipients string) bool {
+	pattern = strings.ToLower(pattern)
+	for _, address := range addresses(recipients) {
+		if ok, _ := path.Match(pattern, address); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDomain checks the sender's domain against a domain and its subdomains
+func matchDomain(domain, from string) bool {
+	domain = strings.ToLower(strings.TrimPrefix(domain, "@"))
+	for _, address := range addresses(from) {
+		at := strings.LastIndex(address, "@")
+		if at < 0 {
+			continue
+		}
+		senderDomain := address[at+1:]
+		if senderDomain == domain || strings.HasSuffix(senderDomain, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// addresses extracts the bare, lower cased addresses from a header value
+func addresses(value string) []string {
+	var result []string
+	if list, err := mail.ParseAddressList(value); err == nil {
+		for _, address := range list {
+			result = append(result, strings.ToLower(address.Address))
+		}
+		return result
+	}
+
+	// Fall back to a plain split for values net/mail refuses, e.g. bare local parts
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, strings.ToLower(part))
+		}
+	}
+	return result
+}
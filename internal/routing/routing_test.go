@@ -0,0 +1,117 @@
+package routing
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	table := &Table{
+		Routes: []Route{
+			{Name: "landstar", BodyContains: "www.LandstarCarriers.com/Loads", Parser: "landstar"},
+			{Name: "by-recipient", Recipient: "*@loads.example.com", Parser: "fullcircle"},
+			{Name: "by-sender", FromDomain: "broker.com", SubjectPattern: `(?i)posted load`, Parser: "fullcircle"},
+			{Name: "fullcircle", BodyContains: "Pick Up", Parser: "fullcircle"},
+		},
+		Default: "fullcircle",
+	}
+	if err := table.compile(); err != nil {
+		t.Fatalf("compile() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		envelope Envelope
+		want     string
+	}{
+		{
+			name:     "body marker in html",
+			envelope: Envelope{BodyHTML: `<a href="https://www.LandstarCarriers.com/Loads/1">load</a>`},
+			want:     "landstar",
+		},
+		{
+			name:     "body marker in plain text",
+			envelope: Envelope{BodyPlain: "See www.LandstarCarriers.com/Loads"},
+			want:     "landstar",
+		},
+		{
+			name:     "first match wins",
+			envelope: Envelope{Recipient: "ops@loads.example.com", BodyPlain: "Pick Up Dallas"},
+			want:     "by-recipient",
+		},
+		{
+			name:     "recipient wildcard in a list",
+			envelope: Envelope{Recipient: "other@x.com, Loads <OPS@loads.example.com>"},
+			want:     "by-recipient",
+		},
+		{
+			name:     "sender subdomain and subject",
+			envelope: Envelope{From: "Dispatch <dispatch@mail.broker.com>", Subject: "Alliance Posted Load"},
+			want:     "by-sender",
+		},
+		{
+			name:     "every condition must match",
+			envelope: Envelope{From: "dispatch@broker.com", Subject: "Invoice"},
+			want:     "fullcircle",
+		},
+		{
+			name:     "lookalike domain does not match",
+			envelope: Envelope{From: "dispatch@notbroker.com", Subject: "Alliance Posted Load", BodyPlain: "Pick Up Dallas"},
+			want:     "fullcircle",
+		},
+		{
+			name:     "default route matched on its own condition",
+			envelope: Envelope{BodyPlain: "Pick Up Dallas"},
+			want:     "fullcircle",
+		},
+		{
+			name:     "nothing matches, default route",
+			envelope: Envelope{Subject: "Newsletter", BodyPlain: "hello"},
+			want:     "fullcircle",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := table.Match(tt.envelope)
+			if route == nil {
+				t.Fatalf("Match() = nil, want %q", tt.want)
+			}
+			if route.Name != tt.want {
+				t.Errorf("Match() = %q, want %q", route.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchQuarantine(t *testing.T) {
+	table := DefaultTable()
+	table.Default = QuarantineRoute
+	if err := table.compile(); err != nil {
+		t.Fatalf("compile() error = %v", err)
+	}
+
+	if route := table.Match(Envelope{Subject: "Newsletter", BodyPlain: "hello"}); route != nil {
+		t.Errorf("Match() = %q, want quarantine", route.Name)
+	}
+	if route := table.Match(Envelope{BodyPlain: "Pick Up Monterey CA 93940 USA"}); route == nil || route.Name != "fullcircle" {
+		t.Errorf("Match() = %v, want fullcircle", route)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name  string
+		table Table
+	}{
+		{name: "missing parser", table: Table{Routes: []Route{{Name: "a"}}}},
+		{name: "unknown parser", table: Table{Routes: []Route{{Name: "a", Parser: "nope"}}}},
+		{name: "bad subject pattern", table: Table{Routes: []Route{{Name: "a", Parser: "landstar", SubjectPattern: "("}}}},
+		{name: "unknown default", table: Table{Routes: []Route{{Name: "a", Parser: "landstar"}}, Default: "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.table.compile(); err == nil {
+				t.Error("compile() error = nil, want an error")
+			}
+		})
+	}
+}
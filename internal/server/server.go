@@ -1,14 +1,26 @@
 package server
 
 import (
+	"context"
+	"time"
+
+	"github.com/3milly4ever/parser-landstar/internal/blobstore"
+	"github.com/3milly4ever/parser-landstar/internal/drift"
+	"github.com/3milly4ever/parser-landstar/internal/fieldstats"
+	"github.com/3milly4ever/parser-landstar/internal/handler"
 	"github.com/3milly4ever/parser-landstar/internal/log"
+	"github.com/3milly4ever/parser-landstar/internal/metrics"
 	"github.com/3milly4ever/parser-landstar/internal/middleware"
 	"github.com/3milly4ever/parser-landstar/internal/routes"
+	"github.com/3milly4ever/parser-landstar/internal/worker"
 	config "github.com/3milly4ever/parser-landstar/pkg"
 	"github.com/gofiber/fiber/v2"
 	"github.com/sirupsen/logrus"
 )
 
+// dbRetryInterval is how often the background jobs retry a database that was down at startup
+const dbRetryInterval = 30 * time.Second
+
 func SetupAndRun() {
 	// Load configuration
 	config.LoadConfig()
@@ -16,6 +28,11 @@ func SetupAndRun() {
 	// Initialize the logger
 	log.InitLogger()
 
+	// Email bodies are offloaded through the blob store, fail now rather than on the first large email
+	if _, err := blobstore.Default(); err != nil {
+		logrus.Fatal("Failed to set up the blob store: ", err)
+	}
+
 	// Create a new Fiber app
 	app := fiber.New()
 
@@ -25,6 +42,37 @@ func SetupAndRun() {
 	// Set up routes
 	routes.Setup(app)
 
+	// Expose Prometheus metrics on their own listener
+	if err := metrics.Serve(config.AppConfig.ServerMetricsAddr); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// Connect and migrate before any background job touches the tables
+	db, err := handler.InitializeDB()
+	if err != nil {
+		logrus.Error("Failed to initialize the database: ", err)
+	}
+
+	// Retry geocoding of order locations the worker saved without coordinates
+	go worker.RunRegeocodeJob(context.Background())
+
+	// Deliver platform notifications the worker could not deliver right away
+	go worker.RunDispatcher(context.Background())
+
+	go func() {
+		// A database that was down at startup is retried until it is back
+		for db == nil {
+			time.Sleep(dbRetryInterval)
+			db, _ = handler.InitializeDB()
+		}
+
+		// Keep the field fill-rate table to a rolling window
+		go fieldstats.RunPruner(context.Background(), db, config.AppConfig.FieldStatsRetention)
+
+		// Watch the parsers for broker template changes
+		go drift.Run(context.Background(), db)
+	}()
+
 	// Start the server on the specified IP and port
 	logrus.Infof("Starting server on %s:%s", config.AppConfig.ServerIP, config.AppConfig.ServerPort)
 	if err := app.Listen(config.AppConfig.ServerIP + ":" + config.AppConfig.ServerPort); err != nil {
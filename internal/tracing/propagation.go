@@ -0,0 +1,44 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// SQSAttributes returns the trace context of ctx as SQS message attributes,
+// ready to be sent with the message
+func SQSAttributes(ctx context.Context) map[string]*sqs.MessageAttributeValue {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	attributes := make(map[string]*sqs.MessageAttributeValue, len(carrier))
+	for key, value := range carrier {
+		attributes[key] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+	return attributes
+}
+
+// FromSQS returns ctx carrying the trace context the producer attached to an SQS message
+func FromSQS(ctx context.Context, attributes map[string]events.SQSMessageAttribute) context.Context {
+	carrier := propagation.MapCarrier{}
+	for key, attribute := range attributes {
+		if attribute.StringValue != nil {
+			carrier[key] = *attribute.StringValue
+		}
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// InjectHTTP adds the trace context of ctx to an outgoing request's headers
+func InjectHTTP(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
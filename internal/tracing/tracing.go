@@ -0,0 +1,117 @@
+// Package tracing follows an email with OpenTelemetry spans from the Mailgun handler,
+// through SQS, to the worker's geocoding, inserts and platform call.
+//
+// The exporter is chosen with TRACING_EXPORTER: otlp sends spans over OTLP/HTTP to
+// TRACING_OTLP_ENDPOINT, stdout prints them for local runs and none, the default,
+// leaves tracing off. Like the metrics recorder, nothing is set up until first use.
+package tracing
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	config "github.com/3milly4ever/parser-landstar/pkg"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/3milly4ever/parser-landstar"
+
+var (
+	provider     *sdktrace.TracerProvider
+	providerOnce sync.Once
+)
+
+// Tracer returns the tracer every span of the parser is started from,
+// setting up the exporter from the configuration on first use
+func Tracer() trace.Tracer {
+	providerOnce.Do(setup)
+	return otel.Tracer(instrumentationName)
+}
+
+func setup() {
+	// Trace context travels in W3C traceparent headers and SQS message attributes
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	cfg := config.AppConfig
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.TracingExporter {
+	case "", "none":
+		return
+	case "otlp":
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.TracingEndpoint)}
+		if cfg.TracingInsecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), options...)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	default:
+		logrus.Warnf("Unknown tracing exporter %q, tracing disabled", cfg.TracingExporter)
+		return
+	}
+	if err != nil {
+		logrus.Error("Failed to create trace exporter, tracing disabled: ", err)
+		return
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.TracingServiceName)))
+	if err != nil {
+		logrus.Warn("Failed to build trace resource: ", err)
+		res = resource.Default()
+	}
+
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	logrus.Infof("Tracing enabled, exporting spans to %s", cfg.TracingExporter)
+}
+
+// Start starts a span as a child of the span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on the span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Flush exports the buffered spans, it is called at the end of every Lambda invocation
+// because the runtime may be frozen before the batcher's next export
+func Flush(ctx context.Context) {
+	if provider == nil {
+		return
+	}
+	if err := provider.ForceFlush(ctx); err != nil {
+		logrus.Warn("Failed to flush spans: ", err)
+	}
+}
+
+// Shutdown flushes and stops the exporter when a long running process exits
+func Shutdown(ctx context.Context) {
+	if provider == nil {
+		return
+	}
+	if err := provider.Shutdown(ctx); err != nil {
+		logrus.Warn("Failed to shut down tracing: ", err)
+	}
+}
@@ -1,10 +1,11 @@
 package trucksize
 
 import (
-	"log"
 	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/sirupsen/logrus"
 )
 
 // TruckType represents a type of truck with an ID and Name.
@@ -130,7 +131,7 @@ func DetermineSuggestedTruckSize(parsedKeyValue map[string]string, args map[stri
 				// Handle special cases for van types.
 				lowerSize := strings.ToLower(suggestedTruckSize)
 				if lowerSize == "cargo van" || lowerSize == "van" || lowerSize == "cube van" || lowerSize == "sprinter van" {
-					log.Printf("TRUCK_SIZE_CARGO_VAN: %+v", parsedKeyValue)
+					logrus.WithField("fields", parsedKeyValue).Info("Cargo van requested, suggesting SPRINTER")
 					parsedKeyValue["suggested_truck_size"] = "SPRINTER"
 				} else {
 					parsedKeyValue["suggested_truck_size"] = "SMALL STRAIGHT"
@@ -152,5 +153,5 @@ func DetermineSuggestedTruckSize(parsedKeyValue map[string]string, args map[stri
 	}
 
 	// Optionally, log the final suggested truck size.
-	log.Printf("Final suggested_truck_size: %s", parsedKeyValue["suggested_truck_size"])
+	logrus.WithField("suggested_truck_size", parsedKeyValue["suggested_truck_size"]).Info("Determined suggested truck size")
 }
@@ -0,0 +1,111 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	models "github.com/3milly4ever/parser-landstar/internal/model"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidSubscription is returned for subscriptions with a bad URL or unknown events
+var ErrInvalidSubscription = errors.New("invalid webhook subscription")
+
+// NewSubscription is the request to subscribe a URL to events.
+// A secret is generated when none is given.
+type NewSubscription struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// CreatedSubscription is returned once on creation, it is the only time the secret is shown
+type CreatedSubscription struct {
+	models.WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+// Subscribe validates and stores a subscription
+func Subscribe(ctx context.Context, db *gorm.DB, request NewSubscription) (*CreatedSubscription, error) {
+	if err := validateURL(ctx, request.URL); err != nil {
+		return nil, err
+	}
+
+	if len(request.Events) == 0 {
+		return nil, fmt.Errorf("%w: at least one event is required", ErrInvalidSubscription)
+	}
+	for _, event := range request.Events {
+		if event != "*" && !knownEvent(event) {
+			return nil, fmt.Errorf("%w: unknown event %q", ErrInvalidSubscription, event)
+		}
+	}
+
+	secret := request.Secret
+	if secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+		}
+		secret = hex.EncodeToString(buf)
+	}
+
+	now := time.Now()
+	subscription := models.WebhookSubscription{
+		Name:      request.Name,
+		URL:       request.URL,
+		Events:    strings.Join(request.Events, ","),
+		Secret:    secret,
+		Active:    true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := db.WithContext(ctx).Create(&subscription).Error; err != nil {
+		return nil, fmt.Errorf("failed to save webhook subscription: %w", err)
+	}
+	return &CreatedSubscription{WebhookSubscription: subscription, Secret: secret}, nil
+}
+
+// Unsubscribe deactivates a subscription, queued deliveries to it are dropped.
+// Its delivery history is kept.
+func Unsubscribe(ctx context.Context, db *gorm.DB, id int) error {
+	result := db.WithContext(ctx).Model(&models.WebhookSubscription{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"active":     false,
+		"updated_at": time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Subscriptions lists every subscription, active or not
+func Subscriptions(ctx context.Context, db *gorm.DB) ([]models.WebhookSubscription, error) {
+	var subscriptions []models.WebhookSubscription
+	err := db.WithContext(ctx).Order("id").Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+// Deliveries lists the most recent delivery attempts of a subscription
+func Deliveries(ctx context.Context, db *gorm.DB, subscriptionID, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := db.WithContext(ctx).Where("subscription_id = ?", subscriptionID).Order("id DESC").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+func knownEvent(event string) bool {
+	for _, known := range Events {
+		if known == event {
+			return true
+		}
+	}
+	return false
+}
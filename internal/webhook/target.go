@@ -0,0 +1,77 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// validateURL accepts only https URLs whose host resolves to public addresses,
+// so a subscription cannot point the server at itself or the internal network
+func validateURL(ctx context.Context, rawURL string) error {
+	target, err := url.Parse(rawURL)
+	if err != nil || target.Scheme != "https" || target.Hostname() == "" {
+		return fmt.Errorf("%w: url must be an absolute https URL", ErrInvalidSubscription)
+	}
+	if target.User != nil {
+		return fmt.Errorf("%w: url must not contain credentials", ErrInvalidSubscription)
+	}
+
+	host := target.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if !publicIP(ip) {
+			return fmt.Errorf("%w: url must not point to a private address", ErrInvalidSubscription)
+		}
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("%w: host %q does not resolve", ErrInvalidSubscription, host)
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return fmt.Errorf("%w: url must not point to a private address", ErrInvalidSubscription)
+		}
+	}
+	return nil
+}
+
+// publicIP rejects loopback, private, link-local (including cloud metadata), multicast and unspecified addresses
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// newClient checks the address again when connecting, DNS can change after the subscription was validated
+func newClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("webhook target %s is not a public address", host)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
+		// A redirect could lead anywhere, deliveries must be answered by the subscribed URL
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,207 @@
+// Package webhook pushes order events to subscribed downstream systems.
+//
+// Events are queued through the outbox in the transaction that changes the order, one
+// outbox message per matching subscription, so retries and backoff come from the outbox
+// dispatcher. Every attempt is recorded in the webhook_delivery table.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	models "github.com/3milly4ever/parser-landstar/internal/model"
+	"github.com/3milly4ever/parser-landstar/internal/outbox"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Events a subscription can filter on
+const (
+	EventOrderCreated   = "order.created"
+	EventOrderUpdated   = "order.updated"
+	EventOrderCancelled = "order.cancelled"
+	EventOrderIgnored   = "order.ignored"
+)
+
+// Events lists every event name, for validating subscriptions
+var Events = []string{EventOrderCreated, EventOrderUpdated, EventOrderCancelled, EventOrderIgnored}
+
+// KindWebhook is the outbox kind of a queued webhook delivery
+const KindWebhook = "webhook"
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-Parser-Event"
+	HeaderDelivery  = "X-Parser-Delivery"
+	HeaderSignature = "X-Parser-Signature"
+)
+
+var client = newClient()
+
+func init() {
+	outbox.Register(KindWebhook, deliver)
+}
+
+// OrderData is the payload of the order events
+type OrderData struct {
+	Order    models.Order          `json:"order"`
+	Location *models.OrderLocation `json:"location,omitempty"`
+	Item     *models.OrderItem     `json:"item,omitempty"`
+}
+
+// IgnoredData is the payload of order.ignored, the email never became an order
+type IgnoredData struct {
+	Parser      string `json:"parser"`
+	Subject     string `json:"subject"`
+	MessageID   string `json:"message_id"`
+	OrderNumber string `json:"order_number"`
+	// Reason is a stable code such as truck_size or quarantined, Detail explains it
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// envelope is the body posted to subscribers
+type envelope struct {
+	Event      string      `json:"event"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+// job is the outbox payload of one delivery
+type job struct {
+	SubscriptionID int             `json:"subscription_id"`
+	Event          string          `json:"event"`
+	Body           json.RawMessage `json:"body"`
+}
+
+// Publish queues an event for every active subscription that wants it.
+// tx should be the transaction that changes the order, orderID is 0 for events without an order.
+func Publish(tx *gorm.DB, event string, orderID int, data interface{}) ([]*models.OutboxMessage, error) {
+	var subscriptions []models.WebhookSubscription
+	if err := tx.Where("active = ?", true).Find(&subscriptions).Error; err != nil {
+		return nil, fmt.Errorf("failed to load webhook subscriptions: %w", err)
+	}
+
+	var body []byte
+	var queued []*models.OutboxMessage
+	for _, subscription := range subscriptions {
+		if !Subscribed(subscription, event) {
+			continue
+		}
+
+		if body == nil {
+			var err error
+			body, err = json.Marshal(envelope{Event: event, OccurredAt: time.Now().UTC(), Data: data})
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal %s event: %w", event, err)
+			}
+		}
+
+		payload, err := json.Marshal(job{SubscriptionID: subscription.ID, Event: event, Body: body})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal webhook job: %w", err)
+		}
+		msg, err := outbox.Enqueue(tx, KindWebhook, orderID, string(payload))
+		if err != nil {
+			return nil, err
+		}
+		queued = append(queued, msg)
+	}
+	return queued, nil
+}
+
+// Subscribed reports whether a subscription's event filter includes the event
+func Subscribed(subscription models.WebhookSubscription, event string) bool {
+	for _, filter := range strings.Split(subscription.Events, ",") {
+		filter = strings.TrimSpace(filter)
+		if filter == "*" || filter == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the signature header value for a body: "t=<unix time>,v1=<hex HMAC-SHA256>".
+// The HMAC covers "<unix time>.<body>" so receivers can reject replayed deliveries.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// deliver posts one queued event to its subscription and records the attempt
+func deliver(ctx context.Context, db *gorm.DB, msg *models.OutboxMessage) error {
+	var j job
+	if err := json.Unmarshal([]byte(msg.Payload), &j); err != nil {
+		return fmt.Errorf("invalid webhook job: %w", err)
+	}
+
+	var subscription models.WebhookSubscription
+	err := db.First(&subscription, j.SubscriptionID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !subscription.Active) {
+		// Unsubscribed since the event was queued, nothing to deliver
+		logrus.WithField("subscription_id", j.SubscriptionID).Info("Webhook subscription removed or inactive, dropping delivery")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load webhook subscription: %w", err)
+	}
+
+	attempt := models.WebhookDelivery{
+		SubscriptionID: subscription.ID,
+		OutboxID:       msg.ID,
+		Event:          j.Event,
+		OrderID:        msg.OrderID,
+		CreatedAt:      time.Now(),
+	}
+
+	start := time.Now()
+	attempt.StatusCode, err = post(ctx, subscription, j, msg.ID)
+	attempt.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	if recordErr := db.WithContext(context.Background()).Create(&attempt).Error; recordErr != nil {
+		logrus.WithField("subscription_id", subscription.ID).Error("Failed to record webhook delivery: ", recordErr)
+	}
+	return err
+}
+
+// post sends the signed body, any status outside 2xx is an error
+func post(ctx context.Context, subscription models.WebhookSubscription, j job, deliveryID int) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(j.Body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, j.Event)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(deliveryID))
+	req.Header.Set(HeaderSignature, Sign(subscription.Secret, time.Now(), j.Body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,202 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/3milly4ever/parser-landstar/internal/contract"
+	"github.com/3milly4ever/parser-landstar/internal/log"
+	models "github.com/3milly4ever/parser-landstar/internal/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Error classes stored with failed messages
+const (
+	ErrorClassMalformed          = "malformed"
+	ErrorClassUnsupportedVersion = "unsupported_version"
+	ErrorClassInvalid            = "invalid"
+	ErrorClassParserLogNotFound  = "parser_log_not_found"
+	ErrorClassBlobStore          = "blob_store"
+	ErrorClassTimeout            = "timeout"
+	ErrorClassProcessing         = "processing"
+)
+
+// classifyError groups processing errors so failed messages can be triaged in bulk
+func classifyError(err error) string {
+	switch {
+	case errors.Is(err, contract.ErrMalformed):
+		return ErrorClassMalformed
+	case errors.Is(err, contract.ErrUnsupportedVersion):
+		return ErrorClassUnsupportedVersion
+	case errors.Is(err, contract.ErrInvalid):
+		return ErrorClassInvalid
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrorClassParserLogNotFound
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return ErrorClassTimeout
+	case strings.Contains(err.Error(), "blob store"), strings.Contains(err.Error(), "checksum"):
+		return ErrorClassBlobStore
+	default:
+		return ErrorClassProcessing
+	}
+}
+
+// recordFailure stores or updates the failed_messages row of an SQS message.
+// It uses its own context so a failure caused by the deadline can still be recorded.
+func recordFailure(ctx context.Context, messageID, body string, processErr error) {
+	db, err := InitializeDB()
+	if err != nil {
+		log.FromContext(ctx).Error("Failed to record failed message: ", err)
+		return
+	}
+
+	now := time.Now()
+	failed := models.FailedMessage{
+		MessageID:     messageID,
+		Body:          body,
+		ErrorClass:    classifyError(processErr),
+		ErrorText:     processErr.Error(),
+		Attempts:      1,
+		Status:        models.FailedMessageOpen,
+		FirstFailedAt: now,
+		LastFailedAt:  now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	err = db.WithContext(context.Background()).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "message_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"error_class":    failed.ErrorClass,
+			"error_text":     failed.ErrorText,
+			"attempts":       gorm.Expr("attempts + 1"),
+			"status":         models.FailedMessageOpen,
+			"last_failed_at": now,
+			"updated_at":     now,
+		}),
+	}).Create(&failed).Error
+	if err != nil {
+		log.FromContext(ctx).Error("Failed to record failed message: ", err)
+	}
+}
+
+// resolveFailure marks an earlier failure of a message as resolved once a redelivery succeeds
+func resolveFailure(ctx context.Context, messageID string) {
+	db, err := InitializeDB()
+	if err != nil {
+		return
+	}
+	err = db.Model(&models.FailedMessage{}).
+		Where("message_id = ? AND status = ?", messageID, models.FailedMessageOpen).
+		Updates(map[string]interface{}{"status": models.FailedMessageResolved, "updated_at": time.Now()}).Error
+	if err != nil {
+		log.FromContext(ctx).Warn("Failed to resolve failed message: ", err)
+	}
+}
+
+// ListFailedMessages returns failed messages, newest failure first. Empty filters match everything.
+func ListFailedMessages(ctx context.Context, status, errorClass string, limit int) ([]models.FailedMessage, error) {
+	db, err := InitializeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	query := db.WithContext(ctx).Omit("body").Order("last_failed_at DESC").Limit(limit)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if errorClass != "" {
+		query = query.Where("error_class = ?", errorClass)
+	}
+
+	var failed []models.FailedMessage
+	return failed, query.Find(&failed).Error
+}
+
+// GetFailedMessage returns one failed message including its body
+func GetFailedMessage(ctx context.Context, id int) (*models.FailedMessage, error) {
+	db, err := InitializeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var failed models.FailedMessage
+	if err := db.WithContext(ctx).First(&failed, id).Error; err != nil {
+		return nil, err
+	}
+	return &failed, nil
+}
+
+// EditFailedMessage replaces the body of a failed message before it is replayed.
+// The new body must satisfy the message contract.
+func EditFailedMessage(ctx context.Context, id int, body string) error {
+	if _, err := contract.Decode([]byte(body)); err != nil {
+		return err
+	}
+
+	db, err := InitializeDB()
+	if err != nil {
+		return err
+	}
+	result := db.WithContext(ctx).Model(&models.FailedMessage{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"body":       body,
+		"updated_at": time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ReplayFailedMessage runs a failed message through processMessage again.
+// On success the message is marked replayed, otherwise the new error is recorded and returned.
+func ReplayFailedMessage(ctx context.Context, id int) error {
+	failed, err := GetFailedMessage(ctx, id)
+	if err != nil {
+		return err
+	}
+	if failed.Status != models.FailedMessageOpen {
+		return fmt.Errorf("failed message %d is already %s", id, failed.Status)
+	}
+
+	db, err := InitializeDB()
+	if err != nil {
+		return err
+	}
+
+	ctx = log.WithFields(ctx, logrus.Fields{
+		"failed_message_id":   failed.ID,
+		log.FieldSQSMessageID: failed.MessageID,
+	})
+	logger := log.FromContext(ctx)
+
+	now := time.Now()
+	if processErr := processMessage(ctx, failed.Body); processErr != nil {
+		logger.Error("Replay failed: ", processErr)
+		err := db.WithContext(context.Background()).Model(failed).Updates(map[string]interface{}{
+			"error_class":    classifyError(processErr),
+			"error_text":     processErr.Error(),
+			"attempts":       gorm.Expr("attempts + 1"),
+			"last_failed_at": now,
+			"updated_at":     now,
+		}).Error
+		if err != nil {
+			logger.Error("Failed to record replay failure: ", err)
+		}
+		return processErr
+	}
+
+	logger.Info("Replayed failed message")
+	return db.WithContext(context.Background()).Model(failed).Updates(map[string]interface{}{
+		"status":      models.FailedMessageReplayed,
+		"replayed_at": now,
+		"updated_at":  now,
+	}).Error
+}
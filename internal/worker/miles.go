@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/3milly4ever/parser-landstar/internal/distance"
+	"github.com/3milly4ever/parser-landstar/internal/log"
+	models "github.com/3milly4ever/parser-landstar/internal/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// estimateMiles fills in the miles of an order whose email did not state them and marks them as estimated.
+// It does nothing until both stops are geocoded, the re-geocode job tries again once they are.
+func estimateMiles(ctx context.Context, order *models.Order, location *models.OrderLocation) bool {
+	from := distance.Point{Lat: location.PickupLat, Lng: location.PickupLng}
+	to := distance.Point{Lat: location.DeliveryLat, Lng: location.DeliveryLng}
+	if from.IsZero() || to.IsZero() {
+		return false
+	}
+
+	miles, method, err := distance.EstimateMiles(ctx, from, to)
+	if err != nil {
+		log.FromContext(ctx).Warn("Failed to estimate miles: ", err)
+		return false
+	}
+
+	order.EstimatedMiles = miles
+	order.MilesEstimated = true
+	location.EstimatedMiles = float64(miles)
+
+	log.FromContext(ctx).WithFields(logrus.Fields{
+		"order_number": order.OrderNumber,
+		"miles":        miles,
+		"method":       method,
+	}).Info("Estimated miles for order without miles")
+	return true
+}
+
+// fillEstimatedMiles estimates the miles of an order saved before its stops could be geocoded
+func fillEstimatedMiles(ctx context.Context, db *gorm.DB, location *models.OrderLocation) error {
+	var order models.Order
+	if err := db.First(&order, location.OrderID).Error; err != nil {
+		return err
+	}
+	if order.EstimatedMiles != 0 || !estimateMiles(ctx, &order, location) {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&order).Updates(map[string]interface{}{
+			"estimated_miles": order.EstimatedMiles,
+			"miles_estimated": true,
+			"updated_at":      now,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(location).Updates(map[string]interface{}{
+			"estimated_miles": location.EstimatedMiles,
+			"updated_at":      now,
+		}).Error
+	})
+}
@@ -0,0 +1,187 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/3milly4ever/parser-landstar/internal/contract"
+	"github.com/3milly4ever/parser-landstar/internal/log"
+	models "github.com/3milly4ever/parser-landstar/internal/model"
+	"gorm.io/gorm"
+)
+
+// fieldChange is one entry of the audit diff
+type fieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// findExistingOrder looks up the live order a broker already sent us under the same order number.
+// The broker is identified by the name of the parser recorded on the order's parser_log record,
+// parser_id is shared by every route and cannot tell brokers apart.
+func findExistingOrder(db *gorm.DB, parser, orderNumber string) (*models.Order, error) {
+	if orderNumber == "" || parser == "" {
+		return nil, nil
+	}
+
+	var order models.Order
+	err := db.Model(&models.Order{}).
+		Joins("JOIN parser_log ON parser_log.order_id = orders.id").
+		Where("parser_log.parser = ? AND orders.order_number = ? AND (orders.status IS NULL OR orders.status <> ?)", parser, orderNumber, models.OrderStatusCancelled).
+		Order("orders.id DESC").
+		First(&order).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// updateOrder applies a re-sent or updated load to the order we already have.
+// It runs inside the caller's transaction.
+func updateOrder(tx *gorm.DB, parserLog *models.ParserLog, existing *models.Order, order models.Order, orderLocation *models.OrderLocation, orderItem *models.OrderItem, msg *contract.OrderMessage, bodyHTML, bodyPlain string) error {
+	changes := diffOrder(*existing, order)
+
+	existing.PickupLocation = order.PickupLocation
+	existing.DeliveryLocation = order.DeliveryLocation
+	existing.PickupDate = order.PickupDate
+	existing.DeliveryDate = order.DeliveryDate
+	existing.SuggestedTruckSize = order.SuggestedTruckSize
+	existing.OriginalTruckSize = order.OriginalTruckSize
+	existing.Notes = order.Notes
+	existing.PickupZip = order.PickupZip
+	existing.DeliveryZip = order.DeliveryZip
+	existing.TruckTypeID = order.TruckTypeID
+	existing.OrderTypeID = order.OrderTypeID
+	existing.EstimatedMiles = order.EstimatedMiles
+	existing.MilesEstimated = order.MilesEstimated
+	existing.UpdatedAt = time.Now()
+
+	if err := tx.Save(existing).Error; err != nil {
+		return fmt.Errorf("failed to update order: %w", err)
+	}
+	logger := log.FromContext(tx.Statement.Context).WithField(log.FieldOrderID, existing.ID)
+	logger.Info("Order updated in database")
+
+	// Replace the location and item while keeping their primary keys
+	var currentLocation models.OrderLocation
+	if err := tx.Where("order_id = ?", existing.ID).First(&currentLocation).Error; err == nil {
+		orderLocation.ID = currentLocation.ID
+		orderLocation.CreatedAt = currentLocation.CreatedAt
+	}
+	orderLocation.OrderID = existing.ID
+	if err := tx.Save(orderLocation).Error; err != nil {
+		return fmt.Errorf("failed to update order location: %w", err)
+	}
+
+	var currentItem models.OrderItem
+	if err := tx.Where("order_id = ?", existing.ID).First(&currentItem).Error; err == nil {
+		orderItem.ID = currentItem.ID
+		orderItem.CreatedAt = currentItem.CreatedAt
+	}
+	orderItem.OrderID = existing.ID
+	if err := tx.Save(orderItem).Error; err != nil {
+		return fmt.Errorf("failed to update order item: %w", err)
+	}
+
+	if err := recordAudit(tx, existing.ID, parserLog.ID, contract.ActionUpdate, changes); err != nil {
+		return fmt.Errorf("failed to save order audit: %w", err)
+	}
+
+	if err := updateParserLog(tx, parserLog, existing.ID, msg, bodyHTML, bodyPlain); err != nil {
+		return fmt.Errorf("failed to update parser log record: %w", err)
+	}
+
+	logger.WithField("changes", len(changes)).Info("Applied load update to existing order")
+
+	return nil
+}
+
+// cancelOrder moves the order referenced by a cancellation email to the cancelled status.
+// It runs inside the caller's transaction and returns nil when there was nothing to cancel.
+func cancelOrder(tx *gorm.DB, parserLog *models.ParserLog, msg *contract.OrderMessage, bodyHTML, bodyPlain string) (*models.Order, error) {
+	orderNumber := msg.OrderNumber
+
+	existing, err := findExistingOrder(tx, parserLog.Parser, orderNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up order to cancel: %w", err)
+	}
+	if existing == nil {
+		// Nothing to cancel, either we never accepted the load or it is already cancelled
+		log.FromContext(tx.Statement.Context).WithField("order_number", orderNumber).Warn("Cancellation received for an unknown order. Skipping message.")
+		return nil, nil
+	}
+
+	changes := map[string]fieldChange{
+		"status": {Old: existing.Status, New: models.OrderStatusCancelled},
+	}
+	existing.Status = models.OrderStatusCancelled
+	existing.UpdatedAt = time.Now()
+
+	if err := tx.Model(existing).Updates(map[string]interface{}{
+		"status":     existing.Status,
+		"updated_at": existing.UpdatedAt,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to cancel order: %w", err)
+	}
+
+	if err := recordAudit(tx, existing.ID, parserLog.ID, contract.ActionCancel, changes); err != nil {
+		return nil, fmt.Errorf("failed to save order audit: %w", err)
+	}
+
+	if err := updateParserLog(tx, parserLog, existing.ID, msg, bodyHTML, bodyPlain); err != nil {
+		return nil, fmt.Errorf("failed to update parser log record: %w", err)
+	}
+
+	log.FromContext(tx.Statement.Context).WithField(log.FieldOrderID, existing.ID).Info("Order cancelled")
+	return existing, nil
+}
+
+// recordAudit writes an audit entry for an order change
+func recordAudit(db *gorm.DB, orderID, parserLogID int, action string, changes map[string]fieldChange) error {
+	changesJSON := []byte("{}")
+	if len(changes) > 0 {
+		var err error
+		changesJSON, err = json.Marshal(changes)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit changes: %w", err)
+		}
+	}
+
+	return db.Create(&models.OrderAudit{
+		OrderID:     orderID,
+		ParserLogID: parserLogID,
+		Action:      action,
+		Changes:     string(changesJSON),
+		CreatedAt:   time.Now(),
+	}).Error
+}
+
+// diffOrder lists the order fields a load update changes
+func diffOrder(old, updated models.Order) map[string]fieldChange {
+	changes := map[string]fieldChange{}
+	compare := func(field string, oldValue, newValue interface{}) {
+		if oldValue != newValue {
+			changes[field] = fieldChange{Old: oldValue, New: newValue}
+		}
+	}
+
+	compare("pickup_location", old.PickupLocation, updated.PickupLocation)
+	compare("delivery_location", old.DeliveryLocation, updated.DeliveryLocation)
+	compare("pickup_date", old.PickupDate.Format(time.RFC3339), updated.PickupDate.Format(time.RFC3339))
+	compare("delivery_date", old.DeliveryDate.Format(time.RFC3339), updated.DeliveryDate.Format(time.RFC3339))
+	compare("suggested_truck_size", old.SuggestedTruckSize, updated.SuggestedTruckSize)
+	compare("original_truck_size", old.OriginalTruckSize, updated.OriginalTruckSize)
+	compare("notes", old.Notes, updated.Notes)
+	compare("pickup_zip", old.PickupZip, updated.PickupZip)
+	compare("delivery_zip", old.DeliveryZip, updated.DeliveryZip)
+	compare("truck_type_id", old.TruckTypeID, updated.TruckTypeID)
+	compare("order_type_id", old.OrderTypeID, updated.OrderTypeID)
+	compare("estimated_miles", old.EstimatedMiles, updated.EstimatedMiles)
+
+	return changes
+}
@@ -0,0 +1,40 @@
+package worker
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	models "github.com/3milly4ever/parser-landstar/internal/model"
+)
+
+func TestDiffOrder(t *testing.T) {
+	base := models.Order{OrderNumber: "82163", PickupZip: "93940", TruckTypeID: 1, OrderTypeID: 4, Notes: "call first"}
+
+	tests := []struct {
+		name   string
+		update func(*models.Order)
+		want   []string
+	}{
+		{name: "unchanged", update: func(*models.Order) {}},
+		{name: "truck type", update: func(o *models.Order) { o.TruckTypeID = 2 }, want: []string{"truck_type_id"}},
+		{name: "order type", update: func(o *models.Order) { o.OrderTypeID = 5 }, want: []string{"order_type_id"}},
+		{name: "zip and notes", update: func(o *models.Order) { o.PickupZip = "93950"; o.Notes = "" }, want: []string{"notes", "pickup_zip"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := base
+			tt.update(&updated)
+
+			var got []string
+			for field := range diffOrder(base, updated) {
+				got = append(got, field)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffOrder() changed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
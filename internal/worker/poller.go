@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/3milly4ever/parser-landstar/internal/log"
+	"github.com/3milly4ever/parser-landstar/internal/metrics"
+	config "github.com/3milly4ever/parser-landstar/pkg"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/sirupsen/logrus"
+)
+
+// RunPoller is the long running alternative to the Lambda trigger: it long-polls the
+// queue and processes each batch with the same code as LambdaHandler until ctx is cancelled.
+// Each batch gets WORKER_VISIBILITY_TIMEOUT to finish, minus WORKER_DEADLINE_MARGIN,
+// so unfinished messages become visible again instead of being processed twice.
+func RunPoller(ctx context.Context) error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+	client := sqs.New(sess, aws.NewConfig().WithMaxRetries(3))
+	queueURL := config.AppConfig.SQSQueueURL
+	visibility := config.AppConfig.WorkerVisibilityTimeout
+
+	logrus.WithField("queue_url", queueURL).Info("Worker polling SQS")
+	for ctx.Err() == nil {
+		output, err := client.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(20),
+			VisibilityTimeout:   aws.Int64(int64(visibility.Seconds())),
+			// The trace context travels in the message attributes
+			MessageAttributeNames: aws.StringSlice([]string{"All"}),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			logrus.Error("Failed to receive SQS messages: ", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		if len(output.Messages) == 0 {
+			continue
+		}
+
+		event := events.SQSEvent{Records: make([]events.SQSMessage, 0, len(output.Messages))}
+		for _, message := range output.Messages {
+			attributes := make(map[string]events.SQSMessageAttribute, len(message.MessageAttributes))
+			for name, value := range message.MessageAttributes {
+				attributes[name] = events.SQSMessageAttribute{
+					DataType:    aws.StringValue(value.DataType),
+					StringValue: value.StringValue,
+				}
+			}
+			event.Records = append(event.Records, events.SQSMessage{
+				MessageId:         aws.StringValue(message.MessageId),
+				ReceiptHandle:     aws.StringValue(message.ReceiptHandle),
+				Body:              aws.StringValue(message.Body),
+				MessageAttributes: attributes,
+			})
+		}
+
+		// Finish the batch even if we are asked to stop, its messages are already invisible
+		batchCtx, cancel := context.WithTimeout(context.Background(), visibility)
+		response, _ := LambdaHandler(batchCtx, event)
+		cancel()
+
+		deleteProcessed(client, queueURL, event, response)
+	}
+
+	logrus.Info("Worker stopped polling SQS")
+	return nil
+}
+
+// deleteProcessed removes every message of the batch that was not reported as failed
+func deleteProcessed(client *sqs.SQS, queueURL string, event events.SQSEvent, response events.SQSEventResponse) {
+	failed := make(map[string]bool, len(response.BatchItemFailures))
+	for _, failure := range response.BatchItemFailures {
+		failed[failure.ItemIdentifier] = true
+	}
+
+	var entries []*sqs.DeleteMessageBatchRequestEntry
+	for _, record := range event.Records {
+		if !failed[record.MessageId] {
+			entries = append(entries, &sqs.DeleteMessageBatchRequestEntry{
+				Id:            aws.String(record.MessageId),
+				ReceiptHandle: aws.String(record.ReceiptHandle),
+			})
+		}
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	output, err := client.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String(queueURL),
+		Entries:  entries,
+	})
+	if err != nil {
+		logrus.Error("Failed to delete processed SQS messages: ", err)
+		return
+	}
+	for _, failure := range output.Failed {
+		logrus.WithField(log.FieldSQSMessageID, aws.StringValue(failure.Id)).Error("Failed to delete SQS message: ", aws.StringValue(failure.Message))
+	}
+	for range output.Successful {
+		metrics.IncrementMessagesDeleted()
+	}
+}
@@ -0,0 +1,213 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/3milly4ever/parser-landstar/internal/contract"
+	"github.com/3milly4ever/parser-landstar/internal/log"
+	models "github.com/3milly4ever/parser-landstar/internal/model"
+	"github.com/3milly4ever/parser-landstar/internal/tracing"
+	config "github.com/3milly4ever/parser-landstar/pkg"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// stopGeocode is the geocoding outcome of one pickup or delivery stop.
+// An empty address means the stop is missing the fields needed to geocode it.
+type stopGeocode struct {
+	address string
+	lat     float64
+	lng     float64
+	county  string
+	err     error
+}
+
+// geocodeStop geocodes a stop without failing the message, errors are kept in the outcome
+func geocodeStop(ctx context.Context, name string, location contract.Location) stopGeocode {
+	stop := stopGeocode{address: geocodingAddress(location)}
+
+	ctx, span := tracing.Start(ctx, "worker.geocode."+name, attribute.String("geocode.address", stop.address))
+	defer func() { tracing.End(span, stop.err) }()
+
+	logger := log.FromContext(ctx)
+	if stop.address == "" {
+		logger.Warnf("Missing fields for %s address. Skipping geocoding for %s location.", name, name)
+		return stop
+	}
+
+	stop.lat, stop.lng, stop.county, stop.err = GeocodeLocation(ctx, stop.address)
+	if stop.err != nil {
+		logger.WithField("address", stop.address).Warnf("Failed to geocode %s location, it will be retried: %v", name, stop.err)
+		return stop
+	}
+
+	logger.WithFields(logrus.Fields{
+		"lat":    stop.lat,
+		"lng":    stop.lng,
+		"county": stop.county,
+	}).Infof("Geocoded %s location", name)
+	return stop
+}
+
+// setGeocodeStatus records on the location whether its stops were geocoded.
+// Failed lookups make the location pending with an exponential backoff until
+// REGEOCODE_MAX_ATTEMPTS is reached; an incomplete address can never succeed and fails straight away.
+func setGeocodeStatus(location *models.OrderLocation, stops ...stopGeocode) {
+	var errs []string
+	incomplete := false
+	for _, stop := range stops {
+		switch {
+		case stop.address == "":
+			incomplete = true
+		case stop.err != nil:
+			errs = append(errs, stop.err.Error())
+		}
+	}
+
+	switch {
+	case len(errs) > 0:
+		location.GeocodeAttempts++
+		location.GeocodeError = strings.Join(errs, "; ")
+		if location.GeocodeAttempts >= config.AppConfig.RegeocodeMaxAttempts {
+			location.GeocodeStatus = models.GeocodeStatusFailed
+			location.GeocodeNextAttemptAt = nil
+			return
+		}
+		next := time.Now().Add(regeocodeDelay(location.GeocodeAttempts))
+		location.GeocodeStatus = models.GeocodeStatusPending
+		location.GeocodeNextAttemptAt = &next
+	case incomplete:
+		location.GeocodeStatus = models.GeocodeStatusFailed
+		location.GeocodeError = "address is incomplete"
+		location.GeocodeNextAttemptAt = nil
+	default:
+		location.GeocodeStatus = models.GeocodeStatusOK
+		location.GeocodeError = ""
+		location.GeocodeNextAttemptAt = nil
+	}
+}
+
+// regeocodeDelay doubles the wait after every failed attempt, up to REGEOCODE_MAX_DELAY
+func regeocodeDelay(attempts int) time.Duration {
+	delay := config.AppConfig.RegeocodeBaseDelay
+	for i := 1; i < attempts && delay < config.AppConfig.RegeocodeMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > config.AppConfig.RegeocodeMaxDelay {
+		delay = config.AppConfig.RegeocodeMaxDelay
+	}
+	return delay
+}
+
+// RunRegeocodeJob retries pending order locations every REGEOCODE_INTERVAL until ctx is cancelled.
+// It is meant to run in the long lived server process.
+func RunRegeocodeJob(ctx context.Context) {
+	if config.AppConfig.RegeocodeInterval <= 0 {
+		logrus.Info("Re-geocode job disabled")
+		return
+	}
+
+	ticker := time.NewTicker(config.AppConfig.RegeocodeInterval)
+	defer ticker.Stop()
+
+	logrus.Infof("Re-geocode job started, running every %s", config.AppConfig.RegeocodeInterval)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := retryPendingGeocodes(ctx); err != nil {
+				logrus.Error("Re-geocode job failed: ", err)
+			}
+		}
+	}
+}
+
+// retryPendingGeocodes geocodes one batch of locations whose next attempt is due
+func retryPendingGeocodes(ctx context.Context) error {
+	db, err := InitializeDB()
+	if err != nil {
+		return err
+	}
+	db = db.WithContext(ctx)
+
+	var locations []models.OrderLocation
+	err = db.Where("geocode_status = ? AND geocode_next_attempt_at <= ?", models.GeocodeStatusPending, time.Now()).
+		Order("geocode_next_attempt_at").
+		Limit(config.AppConfig.RegeocodeBatchSize).
+		Find(&locations).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range locations {
+		location := &locations[i]
+		ctx := log.WithField(ctx, log.FieldOrderID, location.OrderID)
+		db := db.WithContext(ctx)
+		logger := log.FromContext(ctx)
+
+		pickup := retryStop(ctx, "pickup", contract.Location{
+			City:        location.PickupCity,
+			State:       location.PickupState,
+			PostalCode:  location.PickupPostalCode,
+			CountryCode: location.PickupCountryCode,
+		}, location.PickupLat, location.PickupLng, location.PickupCounty)
+		delivery := retryStop(ctx, "delivery", contract.Location{
+			City:        location.DeliveryCity,
+			State:       location.DeliveryState,
+			PostalCode:  location.DeliveryPostalCode,
+			CountryCode: location.DeliveryCountryCode,
+		}, location.DeliveryLat, location.DeliveryLng, location.DeliveryCounty)
+
+		location.PickupLat, location.PickupLng, location.PickupCounty = pickup.lat, pickup.lng, pickup.county
+		location.DeliveryLat, location.DeliveryLng, location.DeliveryCounty = delivery.lat, delivery.lng, delivery.county
+		location.UpdatedAt = time.Now()
+		setGeocodeStatus(location, pickup, delivery)
+
+		err := db.Model(location).Select(
+			"pickup_lat", "pickup_lng", "pickup_county",
+			"delivery_lat", "delivery_lng", "delivery_county",
+			"geocode_status", "geocode_attempts", "geocode_next_attempt_at", "geocode_error", "updated_at",
+		).Updates(location).Error
+		if err != nil {
+			logger.WithField("order_location_id", location.ID).Error("Failed to save re-geocoded location: ", err)
+			continue
+		}
+
+		logger = logger.WithFields(logrus.Fields{
+			"geocode_status": location.GeocodeStatus,
+			"attempts":       location.GeocodeAttempts,
+		})
+		if location.GeocodeStatus != models.GeocodeStatusOK {
+			logger.Warn("Re-geocoding order location did not succeed")
+			continue
+		}
+
+		if location.EstimatedMiles == 0 {
+			if err := fillEstimatedMiles(ctx, db, location); err != nil {
+				logger.Error("Failed to estimate miles for re-geocoded order: ", err)
+			}
+		}
+
+		// Let the platform pick up the coordinates
+		logger.Info("Re-geocoded order location")
+		dispatch, err := enqueuePlatformOrder(db, location.OrderID)
+		if err != nil {
+			logger.Error("Failed to queue platform notification for re-geocoded order: ", err)
+			continue
+		}
+		deliverNow(ctx, db, nil, dispatch)
+	}
+	return nil
+}
+
+// retryStop geocodes a stop again unless it already has coordinates
+func retryStop(ctx context.Context, name string, location contract.Location, lat, lng float64, county string) stopGeocode {
+	address := geocodingAddress(location)
+	if address == "" || lat != 0 || lng != 0 {
+		return stopGeocode{address: address, lat: lat, lng: lng, county: county}
+	}
+	return geocodeStop(ctx, name, location)
+}
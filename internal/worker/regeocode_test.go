@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/3milly4ever/parser-landstar/internal/contract"
+	"github.com/3milly4ever/parser-landstar/internal/dbtest"
+	"github.com/3milly4ever/parser-landstar/internal/geocode"
+)
+
+func TestRetryStopWritesGeocodeCache(t *testing.T) {
+	location := contract.Location{PostalCode: "93940", City: "Monterey", State: "California", CountryCode: "US"}
+
+	tests := []struct {
+		name string
+		// readOnlyFirst sets up the handler's read-only cache first, as the server does
+		readOnlyFirst bool
+		writer        bool
+		wantWrite     bool
+	}{
+		{name: "worker cache", writer: true, wantWrite: true},
+		{name: "handler initialized first in the server", readOnlyFirst: true, writer: true, wantWrite: true},
+		{name: "handler only", readOnlyFirst: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			geocode.SetDefault(geocode.NewStatic(map[string]geocode.Result{
+				geocodingAddress(location): {Lat: 36.6, Lng: -121.9, County: "Monterey County"},
+			}))
+			defer geocode.SetDefault(nil)
+
+			db, conn := dbtest.Open(t)
+			if tt.readOnlyFirst {
+				if err := geocode.UseReadOnlyCache(db); err != nil {
+					t.Fatalf("UseReadOnlyCache() error = %v", err)
+				}
+			}
+			if tt.writer {
+				if err := geocode.UseCache(db); err != nil {
+					t.Fatalf("UseCache() error = %v", err)
+				}
+			}
+
+			stop := retryStop(context.Background(), "pickup", location, 0, 0, "")
+			if stop.err != nil || stop.lat != 36.6 || stop.county != "Monterey County" {
+				t.Fatalf("retryStop() = %+v", stop)
+			}
+			if got := len(conn.Executed("INSERT INTO `geocode_cache`")) > 0; got != tt.wantWrite {
+				t.Errorf("geocode_cache written = %v, want %v", got, tt.wantWrite)
+			}
+		})
+	}
+}
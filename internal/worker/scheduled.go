@@ -0,0 +1,98 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/3milly4ever/parser-landstar/internal/drift"
+	"github.com/3milly4ever/parser-landstar/internal/fieldstats"
+	"github.com/3milly4ever/parser-landstar/internal/metrics"
+	"github.com/3milly4ever/parser-landstar/internal/outbox"
+	"github.com/3milly4ever/parser-landstar/internal/tracing"
+	config "github.com/3milly4ever/parser-landstar/pkg"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Jobs the scheduled Lambda can run
+const (
+	JobOutbox    = "outbox"
+	JobRegeocode = "regeocode"
+	JobDrift     = "drift"
+	JobPrune     = "prune"
+)
+
+// ScheduledEvent is the input of the scheduled Lambda, set on the EventBridge rule.
+// Without jobs every job runs.
+type ScheduledEvent struct {
+	Jobs []string `json:"jobs"`
+}
+
+var scheduledJobs = map[string]func(ctx context.Context, db *gorm.DB) error{
+	JobOutbox: dispatchOutbox,
+	JobRegeocode: func(ctx context.Context, db *gorm.DB) error {
+		return retryPendingGeocodes(ctx)
+	},
+	JobDrift: drift.RunOnce,
+	JobPrune: func(ctx context.Context, db *gorm.DB) error {
+		return fieldstats.Prune(ctx, db, config.AppConfig.FieldStatsRetention)
+	},
+}
+
+// ScheduledHandler runs one pass of the jobs the server otherwise runs in the background,
+// so a deployment without the server still delivers the outbox and watches for drift.
+// Every job runs even when an earlier one failed, the errors are returned together.
+func ScheduledHandler(ctx context.Context, event ScheduledEvent) error {
+	defer metrics.Flush()
+	defer tracing.Flush(context.Background())
+
+	// Stop starting work before the Lambda deadline, like LambdaHandler
+	if deadline, ok := ctx.Deadline(); ok {
+		margin := min(config.AppConfig.WorkerDeadlineMargin, time.Until(deadline)/4)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline.Add(-margin))
+		defer cancel()
+	}
+
+	db, err := InitializeDB()
+	if err != nil {
+		return err
+	}
+
+	jobs := event.Jobs
+	if len(jobs) == 0 {
+		jobs = []string{JobOutbox, JobRegeocode, JobDrift, JobPrune}
+	}
+
+	var errs []error
+	for _, name := range jobs {
+		job, ok := scheduledJobs[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown scheduled job %q", name))
+			continue
+		}
+		start := time.Now()
+		if err := job(ctx, db.WithContext(ctx)); err != nil {
+			logrus.WithField("job", name).Error("Scheduled job failed: ", err)
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		logrus.WithFields(logrus.Fields{"job": name, "duration": time.Since(start).String()}).Info("Scheduled job done")
+	}
+	return errors.Join(errs...)
+}
+
+// dispatchOutbox delivers batches until no due message is left or the deadline approaches
+func dispatchOutbox(ctx context.Context, db *gorm.DB) error {
+	for {
+		attempted, err := outbox.Dispatch(ctx, db)
+		if err != nil {
+			return err
+		}
+		if attempted == 0 || attempted < config.AppConfig.OutboxBatchSize || ctx.Err() != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package worker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/3milly4ever/parser-landstar/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// stageTimings times the stages of processing one message. Every stage is recorded in the
+// stage_duration_seconds histogram as it ends, and the whole set is logged once the message
+// is done, which shows whether a slow message waited on the geocoder, MySQL or the platform.
+// A nil *stageTimings still records the histogram, for callers outside processMessage.
+type stageTimings struct {
+	mu     sync.Mutex
+	start  time.Time
+	stages []stageTiming
+}
+
+type stageTiming struct {
+	stage    string
+	duration time.Duration
+}
+
+func newStageTimings() *stageTimings {
+	return &stageTimings{start: time.Now()}
+}
+
+// begin starts timing a stage, the returned func ends it
+func (t *stageTimings) begin(stage string) func() {
+	start := time.Now()
+	return func() {
+		duration := time.Since(start)
+		metrics.ObserveStageDuration(stage, duration.Seconds())
+		if t == nil {
+			return
+		}
+		t.mu.Lock()
+		t.stages = append(t.stages, stageTiming{stage: stage, duration: duration})
+		t.mu.Unlock()
+	}
+}
+
+// measure times fn as a stage
+func (t *stageTimings) measure(stage string, fn func() error) error {
+	end := t.begin(stage)
+	defer end()
+	return fn()
+}
+
+// log writes the per-message timing summary, in milliseconds
+func (t *stageTimings) log(logger *logrus.Entry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stages := make(map[string]float64, len(t.stages))
+	for _, timing := range t.stages {
+		// A stage can run more than once, e.g. one dispatch per outbox message
+		stages[timing.stage] += float64(timing.duration.Microseconds()) / 1000
+	}
+	logger.WithFields(logrus.Fields{
+		"stages_ms": stages,
+		"total_ms":  float64(time.Since(t.start).Microseconds()) / 1000,
+	}).Info("Message timing")
+}
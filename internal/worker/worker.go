@@ -2,21 +2,25 @@ package worker
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
-	"net/url"
 	"sync"
 	"time"
 
+	"github.com/3milly4ever/parser-landstar/internal/blobstore"
+	"github.com/3milly4ever/parser-landstar/internal/contract"
+	"github.com/3milly4ever/parser-landstar/internal/geocode"
+	"github.com/3milly4ever/parser-landstar/internal/log"
 	"github.com/3milly4ever/parser-landstar/internal/metrics"
 	models "github.com/3milly4ever/parser-landstar/internal/model"
+	"github.com/3milly4ever/parser-landstar/internal/outbox"
+	"github.com/3milly4ever/parser-landstar/internal/tracing"
+	"github.com/3milly4ever/parser-landstar/internal/webhook"
 	config "github.com/3milly4ever/parser-landstar/pkg"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
@@ -39,176 +43,153 @@ func InitializeDB() (*gorm.DB, error) {
 		// Optionally set database connection pool settings here
 		sqlDB, err := db.DB()
 		if err != nil {
-			log.Fatalf("Failed to get sql.DB from GORM: %v", err)
+			logrus.Fatalf("Failed to get sql.DB from GORM: %v", err)
 		}
 		if err := sqlDB.Ping(); err != nil {
-			log.Fatalf("Failed to ping database: %v", err)
+			logrus.Fatalf("Failed to ping database: %v", err)
 		}
 		// Configure database connection pool settings
 		sqlDB.SetMaxOpenConns(10)
 		sqlDB.SetMaxIdleConns(5)
 		sqlDB.SetConnMaxLifetime(time.Minute * 5)
+
+		// Make sure the tables and columns the worker writes to exist
+		if err = models.Migrate(db); err != nil {
+			logrus.Errorf("Failed to migrate database: %v", err)
+		}
+
+		if err := metrics.InstrumentDB(db); err != nil {
+			logrus.Errorf("Failed to instrument database: %v", err)
+		}
+		if err := tracing.InstrumentDB(db); err != nil {
+			logrus.Errorf("Failed to trace database: %v", err)
+		}
+
+		// Read geocoding lookups through the geocode_cache table
+		if err := geocode.UseCache(db); err != nil {
+			logrus.Errorf("Failed to set up the geocode cache: %v", err)
+		}
 	})
 	return db, err
 }
 
-func LambdaHandler(ctx context.Context, sqsEvent events.SQSEvent) error {
-	// Use a wait group to ensure all goroutines finish before returning
+// LambdaHandler processes a batch of SQS messages and reports only the failed ones back,
+// so Lambda does not redeliver messages that were already turned into orders.
+// The event source mapping must have ReportBatchItemFailures enabled.
+//
+// At most WORKER_CONCURRENCY messages are processed at once. Processing stops
+// WORKER_DEADLINE_MARGIN before the Lambda deadline (capped at a quarter of the
+// remaining time), and messages that were not started by then are reported as
+// failures so SQS hands them out again.
+func LambdaHandler(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	defer metrics.Flush()
+	defer tracing.Flush(context.Background())
+
+	concurrency := config.AppConfig.WorkerConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// Leave enough time before the Lambda deadline to report failures. The margin
+	// is capped so a short function timeout does not expire the context on entry.
+	processCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		margin := min(config.AppConfig.WorkerDeadlineMargin, time.Until(deadline)/4)
+		var cancel context.CancelFunc
+		processCtx, cancel = context.WithDeadline(ctx, deadline.Add(-margin))
+		defer cancel()
+	}
+
 	var wg sync.WaitGroup
-	errChan := make(chan error, len(sqsEvent.Records))
+	var mu sync.Mutex
+	failed := []string{}
+	markFailed := func(messageID string) {
+		mu.Lock()
+		failed = append(failed, messageID)
+		mu.Unlock()
+	}
+
+	semaphore := make(chan struct{}, concurrency)
+	for i, message := range sqsEvent.Records {
+		// Wait for a free slot, unless we run out of time first
+		select {
+		case semaphore <- struct{}{}:
+		case <-processCtx.Done():
+		}
+		if processCtx.Err() != nil {
+			logrus.Warnf("Deadline approaching, returning %d unstarted messages to the queue", len(sqsEvent.Records)-i)
+			for _, unstarted := range sqsEvent.Records[i:] {
+				markFailed(unstarted.MessageId)
+			}
+			break
+		}
 
-	// Process each message concurrently
-	for _, message := range sqsEvent.Records {
 		wg.Add(1)
 		go func(msg events.SQSMessage) {
 			defer wg.Done()
-
-			err := processMessage(msg.Body)
+			defer func() { <-semaphore }()
+
+			// Continue the trace the handler started when it sent the message
+			msgCtx := log.WithField(processCtx, log.FieldSQSMessageID, msg.MessageId)
+			msgCtx, span := tracing.Tracer().Start(tracing.FromSQS(msgCtx, msg.MessageAttributes), "worker.processMessage",
+				trace.WithSpanKind(trace.SpanKindConsumer),
+				trace.WithAttributes(attribute.String("messaging.message.id", msg.MessageId)))
+
+			start := time.Now()
+			err := processMessage(msgCtx, msg.Body)
+			metrics.ObserveProcessingDuration(time.Since(start).Seconds())
+			tracing.End(span, err)
 			if err != nil {
-				log.Printf("Failed to process message: %v", err)
-				metrics.IncrementMessagesFailed()
-				errChan <- err
+				log.FromContext(msgCtx).Error("Failed to process message: ", err)
+				metrics.IncrementMessagesFailed(classifyError(err))
+				markFailed(msg.MessageId)
+				// Keep the payload so it can be replayed once it leaves the queue
+				recordFailure(msgCtx, msg.MessageId, msg.Body, err)
 			} else {
 				metrics.IncrementMessagesProcessed()
+				resolveFailure(msgCtx, msg.MessageId)
 			}
 		}(message)
 	}
 
-	// Wait for all message processing to finish
+	// Wait for all started messages to finish, they stop on their own once processCtx expires
 	wg.Wait()
 
-	close(errChan)
-	var processErrors []error
-	for err := range errChan {
-		processErrors = append(processErrors, err)
+	response := events.SQSEventResponse{
+		BatchItemFailures: []events.SQSBatchItemFailure{},
 	}
-
-	if len(processErrors) > 0 {
-		return fmt.Errorf("%d messages failed to process: %v", len(processErrors), processErrors)
+	for _, messageID := range failed {
+		response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: messageID})
 	}
 
-	return nil
-}
-
-func ExtractCoordinatesAndCounty(geocodingData map[string]interface{}) (float64, float64, string, error) {
-	// Default values in case fields are missing
-	var lat, lng float64
-	var county string
-
-	// Ensure that "features" exist and are an array
-	if features, ok := geocodingData["features"].([]interface{}); ok && len(features) > 0 {
-		// Process the first feature (best match)
-		firstFeature := features[0].(map[string]interface{})
-
-		// Extract coordinates from geometry
-		if geometry, ok := firstFeature["geometry"].(map[string]interface{}); ok {
-			if coordinates, ok := geometry["coordinates"].([]interface{}); ok && len(coordinates) == 2 {
-				lng = coordinates[0].(float64) // Longitude
-				lat = coordinates[1].(float64) // Latitude
-			}
-		}
-
-		// Extract county from properties
-		if properties, ok := firstFeature["properties"].(map[string]interface{}); ok {
-			if countyValue, ok := properties["county"].(string); ok {
-				county = countyValue
-			} else {
-				logrus.Warn("County not found in properties")
-			}
-		}
-	} else {
-		return 0, 0, "", errors.New("no features found in geocoding data")
+	if len(response.BatchItemFailures) > 0 {
+		logrus.Warnf("%d of %d messages failed to process and will be retried", len(response.BatchItemFailures), len(sqsEvent.Records))
 	}
 
-	return lat, lng, county, nil
+	return response, nil
 }
 
-func GeocodeLocation(address string) (float64, float64, string, error) {
-	// Prepare the base URL and query parameters
-	baseURL := "http://207.244.250.222:4000/v1/search"
-	params := url.Values{}
-	params.Add("text", address)
-
-	// Construct the full URL
-	fullURL := fmt.Sprintf("%s?%s", baseURL, params.Encode())
-
-	// Make the HTTP request
-	resp, err := http.Get(fullURL)
+// GeocodeLocation resolves an address to coordinates and a county with the configured geocoder
+func GeocodeLocation(ctx context.Context, address string) (float64, float64, string, error) {
+	logger := log.FromContext(ctx)
+	geocoder, err := geocode.Default()
 	if err != nil {
-		logrus.WithField("url", fullURL).Error("Failed to make geocoding request: ", err)
-		return 0, 0, "", fmt.Errorf("failed to make geocoding request: %w", err)
+		return 0, 0, "", fmt.Errorf("failed to create geocoder: %w", err)
 	}
-	defer resp.Body.Close()
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
+	result, err := geocoder.Geocode(ctx, address)
 	if err != nil {
-		logrus.WithField("url", fullURL).Error("Failed to read geocoding response body: ", err)
-		return 0, 0, "", fmt.Errorf("failed to read geocoding response body: %w", err)
-	}
-
-	// Unmarshal the response into a generic interface
-	var result map[string]interface{}
-	err = json.Unmarshal(body, &result)
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"url":  fullURL,
-			"body": string(body),
-		}).Error("Failed to unmarshal geocoding response: ", err)
-		return 0, 0, "", fmt.Errorf("failed to unmarshal geocoding response: %w", err)
-	}
-
-	// Print the full URL in case of error
-	logrus.WithField("url", fullURL).Info("Geocoding URL sent for address")
-
-	// Check if there are any features in the response
-	features, ok := result["features"].([]interface{})
-	if !ok || len(features) == 0 {
-		logrus.WithField("url", fullURL).Error("No geocoding features found in the response")
-		return 0, 0, "", fmt.Errorf("no geocoding features found in the response")
-	}
-
-	// Extract the first feature's coordinates and county
-	firstFeature := features[0].(map[string]interface{})
-
-	// Extract geometry safely, checking for nil values
-	geometry, ok := firstFeature["geometry"].(map[string]interface{})
-	if !ok {
-		logrus.Error("Geometry field is missing or of invalid type")
-		return 0, 0, "", fmt.Errorf("geometry field is missing")
-	}
-
-	coordinates, ok := geometry["coordinates"].([]interface{})
-	if !ok || len(coordinates) < 2 {
-		logrus.Error("Coordinates field is missing or invalid")
-		return 0, 0, "", fmt.Errorf("coordinates field is missing or invalid")
-	}
-
-	// Ensure coordinates are valid floats
-	lat, latOk := coordinates[1].(float64)
-	lng, lngOk := coordinates[0].(float64)
-	if !latOk || !lngOk {
-		logrus.Error("Coordinates could not be converted to float64")
-		return 0, 0, "", fmt.Errorf("invalid coordinates format")
-	}
-
-	// Extract county, safely checking for nil
-	properties, ok := firstFeature["properties"].(map[string]interface{})
-	if !ok {
-		logrus.Warn("Properties field is missing in geocoding response")
-		return lat, lng, "", nil
+		logger.WithField("address", address).Error("Failed to geocode address: ", err)
+		return 0, 0, "", err
 	}
 
-	county, ok := properties["county"].(string)
-	if !ok {
-		logrus.Warn("County field is missing or not a string in geocoding response")
-		county = ""
+	if result.County == "" {
+		logger.WithField("address", address).Warn("County not found in geocoding result")
 	}
-
-	return lat, lng, county, nil
+	return result.Lat, result.Lng, result.County, nil
 }
 
-func processMessage(messageBody string) error {
+func processMessage(ctx context.Context, messageBody string) error {
 
 	// Ensure the database is initialized
 	db, err := InitializeDB()
@@ -216,335 +197,451 @@ func processMessage(messageBody string) error {
 	if err != nil {
 		return fmt.Errorf("failed to initialize DB: %v", err)
 	}
-	// Log the raw message
-	logrus.WithField("raw_message", messageBody).Info("Processing SQS message")
+	// Every query of this message is cancelled once the Lambda deadline approaches
+	db = db.WithContext(ctx)
+	logger := log.FromContext(ctx)
+
+	// Time every stage and log the summary with whatever IDs we learned by the end
+	timings := newStageTimings()
+	defer func() { timings.log(logger) }()
+
+	// The raw message carries the broker email, only log it at debug level
+	logger.WithField("bytes", len(messageBody)).Info("Processing SQS message")
+	logger.WithField("raw_message", messageBody).Debug("Raw SQS message")
 
 	// Increment the messagesReceived counter
 	metrics.IncrementMessagesReceived()
 
-	// Parse the message body
-	var data map[string]interface{}
-	err = json.Unmarshal([]byte(messageBody), &data)
+	// Strictly decode the message body, anything that does not match the contract is rejected
+	endDecode := timings.begin("decode")
+	msg, err := contract.Decode([]byte(messageBody))
+	endDecode()
 	if err != nil {
-		logrus.Error("Error unmarshalling message: ", err)
-		metrics.IncrementMessagesFailed()
-		return err
+		logger.Error("Rejected SQS message: ", err)
+		return fmt.Errorf("failed to decode message: %w", err)
 	}
-
-	// Extract parserLogID from the message
-	parserLogID := getIntValue(data["parserLogID"])
+	ctx = log.WithFields(ctx, logrus.Fields{
+		log.FieldParserLogID: msg.ParserLogID,
+		log.FieldMessageID:   msg.Email.MessageID,
+	})
+	db = db.WithContext(ctx)
+	logger = log.FromContext(ctx)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int("parser_log.id", msg.ParserLogID),
+		attribute.String("order.number", msg.OrderNumber),
+		attribute.String("order.action", msg.Action),
+	)
 
 	// Fetch the existing parser_log record
 	var parserLog models.ParserLog
-	if err := db.First(&parserLog, parserLogID).Error; err != nil {
-		logrus.Error("Failed to find parser log record: ", err)
-		metrics.IncrementMessagesFailed()
+	err = timings.measure("parser_log_lookup", func() error {
+		return db.First(&parserLog, msg.ParserLogID).Error
+	})
+	if err != nil {
+		logger.Error("Failed to find parser log record: ", err)
 		return err
 	}
 
-	// Log the parsed data to identify potential issues
-	logrus.WithField("parsed_data", data).Info("Parsed SQS message data")
-
-	// Extract key fields
-	pickupCity := getStringValue(data["pickupCity"])
-	pickupZip := getStringValue(data["pickupZip"])
-	pickupState := getStringValue(data["pickupState"])
-	pickupCountryCode := getStringValue(data["pickupCountryCode"])
-	deliveryCity := getStringValue(data["deliveryCity"])
-	deliveryZip := getStringValue(data["deliveryZip"])
-	deliveryState := getStringValue(data["deliveryState"])
-	deliveryCountryCode := getStringValue(data["deliveryCountryCode"])
-	orderNumber := getStringValue(data["orderNumber"])
-	truckTypeID := getIntValue(data["truckTypeID"])
-
-	// Log the extracted fields to check if they are empty
-	logrus.WithFields(logrus.Fields{
-		"pickupCity":          pickupCity,
-		"pickupZip":           pickupZip,
-		"pickupState":         pickupState,
-		"pickupCountryCode":   pickupCountryCode,
-		"deliveryCity":        deliveryCity,
-		"deliveryZip":         deliveryZip,
-		"deliveryState":       deliveryState,
-		"deliveryCountryCode": deliveryCountryCode,
-		"orderNumber":         orderNumber,
-		"truckTypeID":         truckTypeID,
-	}).Info("Extracted key fields")
-
-	// Check if key fields are missing or empty
-	if pickupCity == "" || deliveryCity == "" || orderNumber == "" {
-		logrus.Warn("Missing key fields: pickupCity, deliveryCity, or orderNumber is empty. Skipping message.")
-		metrics.IncrementMessagesFailed()
-		return nil // Skip processing this message
-	}
-
-	// Extract the reply-to email from the parsed data
-	replyTo := getStringValue(data["replyTo"])
-	if replyTo == "" {
-		logrus.Warn("No 'replyTo' field found in the message.")
+	// The order and its platform notification were already committed by an earlier
+	// delivery of this message, the outbox dispatcher takes care of the rest
+	if parserLog.OrderID != 0 {
+		logger.WithField(log.FieldOrderID, parserLog.OrderID).Warn("Message already processed, skipping")
+		return nil
 	}
 
-	// Construct addresses for geocoding only if the necessary fields are present
-	var pickupAddress, deliveryAddress string
-	if pickupZip != "" && pickupCity != "" && pickupState != "" && pickupCountryCode != "" {
-		pickupAddress = fmt.Sprintf("%s, %s, %s, %s", pickupZip, pickupCity, pickupState, pickupCountryCode)
-	} else {
-		logrus.Warn("Missing fields for pickup address. Skipping geocoding for pickup location.")
-	}
-
-	if deliveryZip != "" && deliveryCity != "" && deliveryState != "" && deliveryCountryCode != "" {
-		deliveryAddress = fmt.Sprintf("%s, %s, %s, %s", deliveryZip, deliveryCity, deliveryState, deliveryCountryCode)
-	} else {
-		logrus.Warn("Missing fields for delivery address. Skipping geocoding for delivery location.")
+	// Fetch the bodies before opening the transaction so no connection is held during the download
+	endLoadBodies := timings.begin("load_bodies")
+	bodyHTML, bodyPlain, err := loadBodies(ctx, msg)
+	endLoadBodies()
+	if err != nil {
+		logger.Error("Failed to load email bodies: ", err)
+		return err
 	}
 
-	// Fetch geolocation and county information if addresses are not empty
-	var pickupLat, pickupLng float64
-	var pickupCounty, deliveryCounty string
-	var deliveryLat, deliveryLng float64
-
-	if pickupAddress != "" {
-		pickupLat, pickupLng, pickupCounty, err = GeocodeLocation(pickupAddress)
+	if msg.Action == contract.ActionCancel {
+		var dispatch []*models.OutboxMessage
+		err := timings.measure("cancel_order", func() error {
+			return db.Transaction(func(tx *gorm.DB) error {
+				cancelled, err := cancelOrder(tx, &parserLog, msg, bodyHTML, bodyPlain)
+				if err != nil || cancelled == nil {
+					return err
+				}
+				ctx = log.WithField(ctx, log.FieldOrderID, cancelled.ID)
+				dispatch, err = notifyOrderChange(tx, webhook.EventOrderCancelled, webhook.OrderData{Order: *cancelled})
+				return err
+			})
+		})
 		if err != nil {
-			logrus.Error("Failed to geocode pickup location: ", err)
-			metrics.IncrementMessagesFailed()
+			logger.Error("Failed to cancel order, transaction rolled back: ", err)
 			return err
 		}
-		logrus.WithFields(logrus.Fields{
-			"pickupLat":    pickupLat,
-			"pickupLng":    pickupLng,
-			"pickupCounty": pickupCounty,
-		}).Info("Geocoded pickup location")
+		logger = log.FromContext(ctx)
+		deliverNow(ctx, db.WithContext(ctx), timings, dispatch...)
+		return nil
 	}
 
-	if deliveryAddress != "" {
-		deliveryLat, deliveryLng, deliveryCounty, err = GeocodeLocation(deliveryAddress)
-		if err != nil {
-			logrus.Error("Failed to geocode delivery location: ", err)
-			metrics.IncrementMessagesFailed()
-			return err
-		}
-		logrus.WithFields(logrus.Fields{
-			"deliveryLat":    deliveryLat,
-			"deliveryLng":    deliveryLng,
-			"deliveryCounty": deliveryCounty,
-		}).Info("Geocoded delivery location")
-	}
-
-	// Parse dates with a helper function
-	parseDateTime := func(dateStr string) (time.Time, error) {
-		formats := []string{
-			"2006-01-02 15:04:05",             // Standard MySQL datetime
-			"2006-01-02 15:04",                // Without seconds
-			time.RFC3339,                      // RFC3339 format
-			"2006-01-02 15:04 MST (UTC-0700)", // Format with timezone
-		}
-		var t time.Time
-		var err error
-		for _, format := range formats {
-			t, err = time.Parse(format, dateStr)
-			if err == nil {
-				return t, nil
-			}
-		}
-		return t, err
-	}
+	// Log the extracted fields to check if they are empty
+	logger.WithFields(logrus.Fields{
+		"pickupCity":          msg.Pickup.City,
+		"pickupZip":           msg.Pickup.PostalCode,
+		"pickupState":         msg.Pickup.State,
+		"pickupCountryCode":   msg.Pickup.CountryCode,
+		"deliveryCity":        msg.Delivery.City,
+		"deliveryZip":         msg.Delivery.PostalCode,
+		"deliveryState":       msg.Delivery.State,
+		"deliveryCountryCode": msg.Delivery.CountryCode,
+		"orderNumber":         msg.OrderNumber,
+		"truckTypeID":         msg.TruckTypeID,
+	}).Info("Extracted key fields")
 
-	// Parse pickup and delivery dates
-	pickupDate, err := parseDateTime(getStringValue(data["pickupDate"]))
-	if err != nil {
-		logrus.WithField("pickupDate", data["pickupDate"]).Error("Failed to parse pickupDate: ", err)
-		metrics.IncrementMessagesFailed()
-		return err
+	// Check if key fields are missing or empty
+	if msg.Pickup.City == "" || msg.Delivery.City == "" || msg.OrderNumber == "" {
+		logger.Warn("Missing key fields: pickupCity, deliveryCity, or orderNumber is empty. Skipping message.")
+		metrics.IncrementMessagesIgnored("missing_key_fields")
+		publishIgnored(ctx, db, timings, &parserLog, msg, "missing_key_fields", "pickup city, delivery city or order number is empty")
+		return nil // Skip processing this message
 	}
 
-	deliveryDate, err := parseDateTime(getStringValue(data["deliveryDate"]))
-	if err != nil {
-		logrus.WithField("deliveryDate", data["deliveryDate"]).Error("Failed to parse deliveryDate: ", err)
-		metrics.IncrementMessagesFailed()
-		return err
+	if msg.Email.ReplyTo == "" {
+		logger.Warn("No 'replyTo' field found in the message.")
 	}
 
-	// Create and save the Order record to the database, including TruckTypeID
+	// Geocoding is best effort, a stop that cannot be geocoded now is saved with
+	// zero coordinates and retried by the re-geocode job
+	endPickup := timings.begin("geocode_pickup")
+	pickup := geocodeStop(ctx, "pickup", msg.Pickup)
+	endPickup()
+	endDelivery := timings.begin("geocode_delivery")
+	delivery := geocodeStop(ctx, "delivery", msg.Delivery)
+	endDelivery()
+
+	// Build the Order record, including TruckTypeID
 	order := models.Order{
-		OrderNumber:        getStringValue(data["orderNumber"]),
-		PickupLocation:     getStringValue(data["pickupLocation"]),
-		DeliveryLocation:   getStringValue(data["deliveryLocation"]),
-		PickupDate:         pickupDate,
-		DeliveryDate:       deliveryDate,
-		SuggestedTruckSize: getStringValue(data["suggestedTruckSize"]),
-		Notes:              getStringValue(data["notes"]),
+		OrderNumber:        msg.OrderNumber,
+		PickupLocation:     msg.Pickup.Label,
+		DeliveryLocation:   msg.Delivery.Label,
+		PickupDate:         msg.PickupDate,
+		DeliveryDate:       msg.DeliveryDate,
+		SuggestedTruckSize: msg.SuggestedTruckSize,
+		Notes:              msg.Notes,
 		CreatedAt:          time.Now(),
 		UpdatedAt:          time.Now(),
-		PickupZip:          getStringValue(data["pickupZip"]),
-		DeliveryZip:        getStringValue(data["deliveryZip"]),
-		OrderTypeID:        getIntValue(data["orderTypeID"]),
-		TruckTypeID:        truckTypeID, // Ensure TruckTypeID from SQS is used
-		OriginalTruckSize:  getStringValue(data["originalTruckSize"]),
-		EstimatedMiles:     getIntValue(data["estimatedMiles"]),
+		PickupZip:          msg.Pickup.PostalCode,
+		DeliveryZip:        msg.Delivery.PostalCode,
+		OrderTypeID:        msg.OrderTypeID,
+		TruckTypeID:        msg.TruckTypeID, // Ensure TruckTypeID from SQS is used
+		OriginalTruckSize:  msg.OriginalTruckSize,
+		EstimatedMiles:     msg.EstimatedMiles,
+		Status:             models.OrderStatusActive,
 	}
 
-	logrus.Infof("Inserting order with TruckTypeID: %d", order.TruckTypeID)
-
-	if err := db.Create(&order).Error; err != nil {
-		logrus.Error("Failed to save order: ", err)
-		metrics.IncrementMessagesFailed()
-		return err
-	}
-	logrus.WithField("order_id", order.ID).Info("Order saved to database")
-
-	// Create and save the OrderLocation record to the database
+	// Build the OrderLocation record
 	orderLocation := models.OrderLocation{
 		// Construct the pickup and delivery labels
-		PickupLabel:         fmt.Sprintf("%s, %s, %s, %s", getStringValue(data["pickupZip"]), getStringValue(data["pickupCity"]), getStringValue(data["pickupState"]), getStringValue(data["pickupCountryCode"])),
-		DeliveryLabel:       fmt.Sprintf("%s, %s, %s, %s", getStringValue(data["deliveryZip"]), getStringValue(data["deliveryCity"]), getStringValue(data["deliveryState"]), getStringValue(data["deliveryCountryCode"])),
-		DeliveryStreet:      getStringValue(data["deliveryStreet"]),
-		PickupStreet:        getStringValue(data["pickupStreet"]),
-		OrderID:             order.ID,
-		PickupCountryCode:   getStringValue(data["pickupCountryCode"]),
-		PickupCountryName:   getStringValue(data["pickupCountryName"]),
-		PickupStateCode:     getStringValue(data["pickupStateCode"]),
-		PickupState:         getStringValue(data["pickupState"]),
-		PickupCity:          getStringValue(data["pickupCity"]),
-		PickupPostalCode:    getStringValue(data["pickupZip"]),
-		PickupLat:           pickupLat,    // Latitude from geocoding
-		PickupLng:           pickupLng,    // Longitude from geocoding
-		PickupCounty:        pickupCounty, // County from geocoding
-		DeliveryCountryCode: getStringValue(data["deliveryCountryCode"]),
-		DeliveryCountryName: getStringValue(data["deliveryCountryName"]),
-		DeliveryStateCode:   getStringValue(data["deliveryStateCode"]),
-		DeliveryState:       getStringValue(data["deliveryState"]),
-		DeliveryCity:        getStringValue(data["deliveryCity"]),
-		DeliveryPostalCode:  getStringValue(data["deliveryZip"]),
-		DeliveryLat:         deliveryLat,    // Latitude from geocoding
-		DeliveryLng:         deliveryLng,    // Longitude from geocoding
-		DeliveryCounty:      deliveryCounty, // County from geocoding
-		EstimatedMiles:      getFloatValue(data["estimatedMiles"]),
+		PickupLabel:         locationLabel(msg.Pickup),
+		DeliveryLabel:       locationLabel(msg.Delivery),
+		DeliveryStreet:      msg.Delivery.Street,
+		PickupStreet:        msg.Pickup.Street,
+		PickupCountryCode:   msg.Pickup.CountryCode,
+		PickupCountryName:   msg.Pickup.CountryName,
+		PickupStateCode:     msg.Pickup.StateCode,
+		PickupState:         msg.Pickup.State,
+		PickupCity:          msg.Pickup.City,
+		PickupPostalCode:    msg.Pickup.PostalCode,
+		PickupLat:           pickup.lat,    // Latitude from geocoding
+		PickupLng:           pickup.lng,    // Longitude from geocoding
+		PickupCounty:        pickup.county, // County from geocoding
+		DeliveryCountryCode: msg.Delivery.CountryCode,
+		DeliveryCountryName: msg.Delivery.CountryName,
+		DeliveryStateCode:   msg.Delivery.StateCode,
+		DeliveryState:       msg.Delivery.State,
+		DeliveryCity:        msg.Delivery.City,
+		DeliveryPostalCode:  msg.Delivery.PostalCode,
+		DeliveryLat:         delivery.lat,    // Latitude from geocoding
+		DeliveryLng:         delivery.lng,    // Longitude from geocoding
+		DeliveryCounty:      delivery.county, // County from geocoding
+		EstimatedMiles:      float64(msg.EstimatedMiles),
 		CreatedAt:           time.Now(),
 		UpdatedAt:           time.Now(),
 	}
+	setGeocodeStatus(&orderLocation, pickup, delivery)
 
-	if err := db.Create(&orderLocation).Error; err != nil {
-		logrus.Error("Failed to save order location: ", err)
-		metrics.IncrementMessagesFailed()
-		return err
+	// Emails without miles get an estimate from the coordinates
+	if order.EstimatedMiles == 0 {
+		endMiles := timings.begin("estimate_miles")
+		estimateMiles(ctx, &order, &orderLocation)
+		endMiles()
 	}
-	logrus.WithField("order_location_id", orderLocation.ID).Info("OrderLocation saved to database")
 
-	// Create and save the OrderItem record to the database
+	// Build the OrderItem record
 	orderItem := models.OrderItem{
-		OrderID:   order.ID,
-		Length:    getFloatValue(data["length"]),
-		Width:     getFloatValue(data["width"]),
-		Height:    getFloatValue(data["height"]),
-		Weight:    getFloatValue(data["weight"]),
-		Pieces:    getIntValue(data["pieces"]),
-		Stackable: getBoolValue(data["stackable"]),
-		Hazardous: getBoolValue(data["hazardous"]),
+		Length:    msg.Item.Length,
+		Width:     msg.Item.Width,
+		Height:    msg.Item.Height,
+		Weight:    msg.Item.Weight,
+		Pieces:    msg.Item.Pieces,
+		Stackable: msg.Item.Stackable,
+		Hazardous: msg.Item.Hazardous,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 
-	if err := db.Create(&orderItem).Error; err != nil {
-		logrus.Error("Failed to save order item: ", err)
-		metrics.IncrementMessagesFailed()
+	// Write the order and all of its children in one transaction,
+	// a failure anywhere leaves nothing behind for the redelivery to trip over
+	var dispatch []*models.OutboxMessage
+	// The order that was inserted or, for a re-sent load, updated
+	var orderID int
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// A re-sent or updated load replaces the order we already have for this broker
+		var existing *models.Order
+		err := timings.measure("existing_order_lookup", func() (err error) {
+			existing, err = findExistingOrder(tx, parserLog.Parser, order.OrderNumber)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to look up existing order: %w", err)
+		}
+		if existing != nil {
+			orderID = existing.ID
+			ctx = log.WithField(ctx, log.FieldOrderID, orderID)
+			err := timings.measure("update_order", func() error {
+				return updateOrder(tx, &parserLog, existing, order, &orderLocation, &orderItem, msg, bodyHTML, bodyPlain)
+			})
+			if err != nil {
+				return err
+			}
+			dispatch, err = notifyOrderChange(tx, webhook.EventOrderUpdated, webhook.OrderData{Order: *existing, Location: &orderLocation, Item: &orderItem})
+			return err
+		}
+		if msg.Action == contract.ActionUpdate {
+			logger.WithField("order_number", order.OrderNumber).Warn("Update received for an unknown order, creating it as new")
+		}
+
+		logger.Infof("Inserting order with TruckTypeID: %d", order.TruckTypeID)
+
+		if err := timings.measure("insert_order", func() error { return tx.Create(&order).Error }); err != nil {
+			return fmt.Errorf("failed to save order: %w", err)
+		}
+		orderID = order.ID
+		ctx = log.WithField(ctx, log.FieldOrderID, orderID)
+		logger = log.FromContext(ctx)
+		logger.Info("Order saved to database")
+
+		orderLocation.OrderID = order.ID
+		if err := timings.measure("insert_order_location", func() error { return tx.Create(&orderLocation).Error }); err != nil {
+			return fmt.Errorf("failed to save order location: %w", err)
+		}
+		logger.WithField("order_location_id", orderLocation.ID).Info("OrderLocation saved to database")
+
+		orderItem.OrderID = order.ID
+		if err := timings.measure("insert_order_item", func() error { return tx.Create(&orderItem).Error }); err != nil {
+			return fmt.Errorf("failed to save order item: %w", err)
+		}
+		logger.WithField("order_item_id", orderItem.ID).Info("OrderItem saved to database")
+
+		orderEmail := models.OrderEmail{
+			ReplyTo:   msg.Email.ReplyTo,
+			Subject:   msg.Email.Subject,
+			MessageID: msg.Email.MessageID,
+			OrderID:   order.ID,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		if err := timings.measure("insert_order_email", func() error { return tx.Create(&orderEmail).Error }); err != nil {
+			return fmt.Errorf("failed to save order email: %w", err)
+		}
+		logger.WithField("order_email_id", orderEmail.ID).Info("OrderEmail saved to database")
+
+		err = timings.measure("insert_order_audit", func() error {
+			return recordAudit(tx, order.ID, parserLog.ID, contract.ActionNew, nil)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to save order audit: %w", err)
+		}
+
+		// Link the parser_log record to the new order
+		err = timings.measure("update_parser_log", func() error {
+			return updateParserLog(tx, &parserLog, order.ID, msg, bodyHTML, bodyPlain)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update parser log record: %w", err)
+		}
+		logger.Info("ParserLog updated in database")
+
+		dispatch, err = notifyOrderChange(tx, webhook.EventOrderCreated, webhook.OrderData{Order: order, Location: &orderLocation, Item: &orderItem})
+		return err
+	})
+	if err != nil {
+		logger.Error("Failed to save order, transaction rolled back: ", err)
 		return err
 	}
-	logrus.WithField("order_item_id", orderItem.ID).Info("OrderItem saved to database")
 
-	// Create and save the OrderEmail record to the database
-	orderEmail := models.OrderEmail{
-		ReplyTo:   replyTo,
-		Subject:   getStringValue(data["subject"]),
-		MessageID: getStringValue(data["messageID"]),
-		OrderID:   order.ID,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("order.id", orderID))
+
+	// Only tell the platform and the webhook subscribers once the order is committed
+	logger = log.FromContext(ctx)
+	deliverNow(ctx, db.WithContext(ctx), timings, dispatch...)
+	return nil
+}
+
+// publishIgnored tells webhook subscribers about a message that will not become an order.
+// Failures are only logged, redelivering the message would not change the outcome.
+func publishIgnored(ctx context.Context, db *gorm.DB, timings *stageTimings, parserLog *models.ParserLog, msg *contract.OrderMessage, reason, detail string) {
+	queued, err := webhook.Publish(db, webhook.EventOrderIgnored, 0, webhook.IgnoredData{
+		Parser:      parserLog.Parser,
+		Subject:     msg.Email.Subject,
+		MessageID:   msg.Email.MessageID,
+		OrderNumber: msg.OrderNumber,
+		Reason:      reason,
+		Detail:      detail,
+	})
+	if err != nil {
+		log.FromContext(ctx).Error("Failed to queue order.ignored webhook: ", err)
+		return
 	}
+	deliverNow(ctx, db, timings, queued...)
+}
 
-	if err := db.Create(&orderEmail).Error; err != nil {
-		logrus.Error("Failed to save order email: ", err)
-		metrics.IncrementMessagesFailed()
-		return err
+// geocodingAddress builds the address sent to the geocoder, or "" when a stop is incomplete
+func geocodingAddress(location contract.Location) string {
+	if location.PostalCode == "" || location.City == "" || location.State == "" || location.CountryCode == "" {
+		return ""
 	}
-	logrus.WithField("order_email_id", orderEmail.ID).Info("OrderEmail saved to database")
+	return locationLabel(location)
+}
 
-	// Once processing is complete, update the parser_log record
-	parserLog.Subject = getStringValue(data["subject"])
-	parserLog.BodyHtml = getStringValue(data["bodyHTML"])
-	parserLog.BodyPlain = getStringValue(data["bodyPlain"])
-	parserLog.OrderID = order.ID
-	parserLog.ParserID = 4
-	parserLog.UpdatedAt = time.Now()
+// locationLabel formats a stop as "zip, city, state, country code"
+func locationLabel(location contract.Location) string {
+	return fmt.Sprintf("%s, %s, %s, %s", location.PostalCode, location.City, location.State, location.CountryCode)
+}
 
-	// Save the updated parser_log record
-	if err := db.Save(&parserLog).Error; err != nil {
-		logrus.Error("Failed to update parser log record: ", err)
-		metrics.IncrementMessagesFailed()
-		return err
-	} else {
-		logrus.WithField("parser_log_id", parserLog.ID).Info("ParserLog updated in database")
-	}
+// defaultParserID is the parser id the worker always wrote before routes carried one
+const defaultParserID = 4
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://platform.hfield.net/api/send_order?order_id=%d", order.ID), nil)
-	if err != nil {
-		logrus.Error("Failed to create HTTP request: ", err)
-		return err
+// updateParserLog links the parser_log record to the order it produced
+func updateParserLog(db *gorm.DB, parserLog *models.ParserLog, orderID int, msg *contract.OrderMessage, bodyHTML, bodyPlain string) error {
+	parserLog.Subject = msg.Email.Subject
+	parserLog.BodyHtml = bodyHTML
+	parserLog.BodyPlain = bodyPlain
+	parserLog.OrderID = orderID
+	// Rows from routes without a parser_id, and from before routing, keep the id every parser_log had
+	if parserLog.ParserID == 0 {
+		parserLog.ParserID = defaultParserID
 	}
+	parserLog.UpdatedAt = time.Now()
+	return db.Save(parserLog).Error
+}
 
-	req.Header.Set("Content-Type", "application/json") // Optional for GET, but can be included if necessary
+// Platform notifications go through the outbox, which retries them
+func init() {
+	outbox.Register(outbox.KindPlatformOrder, func(ctx context.Context, db *gorm.DB, msg *models.OutboxMessage) error {
+		return sendOrderToPlatform(ctx, msg.OrderID)
+	})
+}
 
-	for retries := 0; retries < 3; retries++ {
-		client := &http.Client{
-			Timeout: 10 * time.Second, // Adding a timeout to prevent hanging
-		}
-		resp, err := client.Do(req)
+// sendOrderToPlatform tells the platform that an order was created or changed.
+// It makes a single attempt, retries are left to the outbox dispatcher.
+func sendOrderToPlatform(ctx context.Context, orderID int) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "platform.SendOrder",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("order.id", orderID)))
+	defer func() { tracing.End(span, err) }()
 
-		if err == nil {
-			defer resp.Body.Close() // Ensure the response body is closed if successful
+	client := &http.Client{
+		Timeout: 10 * time.Second, // Adding a timeout to prevent hanging
+	}
 
-			if resp.StatusCode == http.StatusOK {
-				logrus.Info("Successfully sent order ID to external API")
-				return nil // Exit loop upon success
-			}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s?order_id=%d", config.AppConfig.PlatformSendOrderURL, orderID), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json") // Optional for GET, but can be included if necessary
+	tracing.InjectHTTP(ctx, req.Header)
 
-			logrus.WithField("status_code", resp.StatusCode).Error("External API call failed with non-200 status code")
-		} else {
-			logrus.WithField("retry", retries+1).Error("Retrying failed API call due to error", err)
-		}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("external API call failed: %w", err)
+	}
+	resp.Body.Close()
 
-		time.Sleep(time.Duration(retries+1) * time.Second) // Exponential backoff
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("external API call failed with status code %d", resp.StatusCode)
 	}
 
-	return fmt.Errorf("external API call failed after 3 retries")
+	log.FromContext(ctx).WithField(log.FieldOrderID, orderID).Info("Successfully sent order ID to external API")
+	return nil
+}
 
+// enqueuePlatformOrder queues the platform notification in the transaction that changes the order
+func enqueuePlatformOrder(tx *gorm.DB, orderID int) (*models.OutboxMessage, error) {
+	return outbox.Enqueue(tx, outbox.KindPlatformOrder, orderID, "")
 }
 
-func getFloatValue(data interface{}) float64 {
-	if value, ok := data.(float64); ok {
-		return value
+// notifyOrderChange queues the platform notification and the webhook event for an order change
+func notifyOrderChange(tx *gorm.DB, event string, data webhook.OrderData) ([]*models.OutboxMessage, error) {
+	platform, err := enqueuePlatformOrder(tx, data.Order.ID)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := webhook.Publish(tx, event, data.Order.ID, data)
+	if err != nil {
+		return nil, err
 	}
-	return 0.0
+	return append([]*models.OutboxMessage{platform}, webhooks...), nil
 }
 
-func getIntValue(data interface{}) int {
-	if value, ok := data.(float64); ok {
-		return int(value)
+// deliverNow tries to deliver freshly committed outbox messages right away so the platform
+// hears about the order without waiting for the dispatcher. A failure does not fail the
+// SQS message, the order is already saved and the dispatcher retries the delivery.
+// Each delivery is timed as a dispatch_<kind> stage.
+func deliverNow(ctx context.Context, db *gorm.DB, timings *stageTimings, msgs ...*models.OutboxMessage) {
+	for _, msg := range msgs {
+		err := timings.measure("dispatch_"+msg.Kind, func() error { return outbox.Deliver(ctx, db, msg) })
+		if err != nil {
+			log.FromContext(ctx).WithFields(logrus.Fields{
+				log.FieldOrderID: msg.OrderID,
+				"kind":           msg.Kind,
+			}).Warn("Outbox delivery failed, the dispatcher will retry it: ", err)
+		}
 	}
-	return 0
 }
 
-func getBoolValue(data interface{}) bool {
-	if value, ok := data.(bool); ok {
-		return value
+// RunDispatcher delivers pending outbox messages until ctx is cancelled.
+// It is meant to run in the long lived server process.
+func RunDispatcher(ctx context.Context) {
+	db, err := InitializeDB()
+	if err != nil {
+		logrus.Error("Outbox dispatcher not started: ", err)
+		return
 	}
-	return false
+	outbox.Run(ctx, db)
 }
 
-// Helper functions to handle type conversion
-func getStringValue(value interface{}) string {
-	if v, ok := value.(string); ok {
-		return v
+// loadBodies returns the email bodies, fetching them from the blob store when the message only references them
+func loadBodies(ctx context.Context, msg *contract.OrderMessage) (string, string, error) {
+	bodyHTML, bodyPlain := msg.Email.BodyHTML, msg.Email.BodyPlain
+	if msg.Email.BodyHTMLRef == nil && msg.Email.BodyPlainRef == nil {
+		return bodyHTML, bodyPlain, nil
+	}
+
+	blobStore, err := blobstore.Default()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create blob store: %w", err)
+	}
+
+	if ref := msg.Email.BodyHTMLRef; ref != nil {
+		data, err := blobstore.GetVerified(ctx, blobStore, ref.Key, ref.SHA256)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to fetch HTML body: %w", err)
+		}
+		bodyHTML = string(data)
+	}
+	if ref := msg.Email.BodyPlainRef; ref != nil {
+		data, err := blobstore.GetVerified(ctx, blobStore, ref.Key, ref.SHA256)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to fetch plain text body: %w", err)
+		}
+		bodyPlain = string(data)
 	}
-	return ""
+	return bodyHTML, bodyPlain, nil
 }
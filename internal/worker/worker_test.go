@@ -0,0 +1,81 @@
+package worker
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/3milly4ever/parser-landstar/internal/contract"
+	"github.com/3milly4ever/parser-landstar/internal/dbtest"
+	"github.com/3milly4ever/parser-landstar/internal/webhook"
+)
+
+func TestMissingKeyFieldsArePublished(t *testing.T) {
+	testDB, conn := dbtest.Open(t)
+	conn.AddRows("FROM `parser_log`", []string{"id", "parser", "parser_id"}, []driver.Value{int64(7), "landstar", int64(4)})
+	conn.AddRows("`webhook_subscription` WHERE active", []string{"id", "url", "events", "active"},
+		[]driver.Value{int64(1), "https://hooks.example.com/parser", webhook.EventOrderIgnored, true})
+	dbOnce.Do(func() {})
+	db = testDB
+
+	body, err := contract.Encode(&contract.OrderMessage{
+		Action:      contract.ActionNew,
+		ParserLogID: 7,
+		OrderNumber: "82163",
+		Pickup:      contract.Location{City: "Monterey"},
+		Email:       contract.Email{Subject: "SMALL STRAIGHT from MONTEREY, CA", MessageID: "<abc@example.com>"},
+	})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	if err := processMessage(context.Background(), string(body)); err != nil {
+		t.Fatalf("processMessage() error = %v", err)
+	}
+
+	if orders := conn.Executed("INSERT INTO `orders`"); len(orders) != 0 {
+		t.Errorf("created %d orders, want none", len(orders))
+	}
+	queued := conn.Executed("INSERT INTO `outbox`")
+	if len(queued) != 1 {
+		t.Fatalf("queued %d outbox messages, want 1", len(queued))
+	}
+	payload := fmt.Sprint(queued[0].Args)
+	for _, want := range []string{`"reason":"missing_key_fields"`, `"parser":"landstar"`, `"order_number":"82163"`} {
+		if !strings.Contains(payload, want) {
+			t.Errorf("payload %s, want %s", payload, want)
+		}
+	}
+}
+
+func TestScheduledHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		jobs      []string
+		wantErr   bool
+		wantQuery string
+	}{
+		{name: "outbox", jobs: []string{JobOutbox}, wantQuery: "SELECT * FROM `outbox` WHERE status = ?"},
+		{name: "prune", jobs: []string{JobPrune}, wantQuery: "DELETE FROM `parser_field_stats`"},
+		{name: "unknown job", jobs: []string{"backup"}, wantErr: true},
+		{name: "unknown job does not stop the others", jobs: []string{"backup", JobOutbox}, wantErr: true, wantQuery: "SELECT * FROM `outbox` WHERE status = ?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testDB, conn := dbtest.Open(t)
+			dbOnce.Do(func() {})
+			db = testDB
+
+			err := ScheduledHandler(context.Background(), ScheduledEvent{Jobs: tt.jobs})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ScheduledHandler() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantQuery != "" && len(conn.Executed(tt.wantQuery)) == 0 {
+				t.Errorf("no statement starting with %q", tt.wantQuery)
+			}
+		})
+	}
+}
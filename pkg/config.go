@@ -1,37 +1,261 @@
 package config
 
 import (
+	"fmt"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
 )
 
 type Config struct {
-	ServerIP     string
-	ServerPort   string
-	LogFile      string
-	AWSRegion    string
-	AWSAccessKey string
-	AWSSecretKey string
-	SQSQueueURL  string
-	MySQLDSN     string
+	ServerIP      string
+	ServerPort    string
+	LogFile       string
+	LogLevel      string
+	LogFormat     string
+	LogFullBodies bool
+	LogBodyLimit  int
+	AWSRegion     string
+	AWSAccessKey  string
+	AWSSecretKey  string
+	SQSQueueURL   string
+	MySQLDSN      string
+	BlobStore     string
+	BlobLocalDir  string
+	BlobS3Bucket  string
+	BlobS3Prefix  string
+	RoutesFile    string
+	DefaultRoute  string
+
+	// Bodies larger than this many bytes are moved to the blob store, smaller ones stay in the message
+	BlobOffloadThreshold int
+
+	WorkerConcurrency    int
+	WorkerDeadlineMargin time.Duration
+	// Only used by the long running worker, Lambda gets its deadline from the invocation
+	WorkerVisibilityTimeout time.Duration
+
+	GeocoderProvider   string
+	GeocoderURL        string
+	GeocoderTimeout    time.Duration
+	GeocoderStaticFile string
+
+	GeocodeCacheSize        int
+	GeocodeCacheTTL         time.Duration
+	GeocodeCacheNegativeTTL time.Duration
+
+	RegeocodeInterval    time.Duration
+	RegeocodeBatchSize   int
+	RegeocodeBaseDelay   time.Duration
+	RegeocodeMaxDelay    time.Duration
+	RegeocodeMaxAttempts int
+
+	RouterProvider string
+	RouterURL      string
+	CircuityFactor float64
+
+	PlatformSendOrderURL string
+	OutboxPollInterval   time.Duration
+	OutboxBatchSize      int
+	OutboxBaseDelay      time.Duration
+	OutboxMaxDelay       time.Duration
+	OutboxMaxAttempts    int
+
+	MetricsAddr          string
+	ServerMetricsAddr    string
+	MetricsBackend       string
+	MetricsNamespace     string
+	MetricsFlushInterval time.Duration
+
+	FieldStatsRetention time.Duration
+
+	DriftInterval            time.Duration
+	DriftWindow              time.Duration
+	DriftBaseline            time.Duration
+	DriftMinSamples          int
+	DriftMinStructureSamples int
+	DriftRateDrop            float64
+	DriftAlertCooldown       time.Duration
+	DriftAlertWebhookURL     string
+	DriftAlertSecret         string
+	DriftSampleRetention     time.Duration
+
+	TracingExporter    string
+	TracingEndpoint    string
+	TracingInsecure    bool
+	TracingServiceName string
+	TracingSampleRatio float64
+
+	HealthCheckTimeout time.Duration
+
+	AdminToken string
 }
 
 var AppConfig Config
 
+// Used by String to hide the path of a webhook URL
+var urlPathRegex = regexp.MustCompile(`^([a-z]+://[^/]+)/.+$`)
+
+// String prints the configuration with its secrets masked, so it is safe to log
+func (c Config) String() string {
+	c.AWSAccessKey = maskSecret(c.AWSAccessKey)
+	c.AWSSecretKey = maskSecret(c.AWSSecretKey)
+	c.MySQLDSN = maskDSN(c.MySQLDSN)
+	c.GeocoderURL = maskURL(c.GeocoderURL)
+	c.RouterURL = maskURL(c.RouterURL)
+	c.PlatformSendOrderURL = maskURL(c.PlatformSendOrderURL)
+	c.DriftAlertSecret = maskSecret(c.DriftAlertSecret)
+	c.AdminToken = maskSecret(c.AdminToken)
+	// Chat webhooks carry their token in the path
+	c.DriftAlertWebhookURL = urlPathRegex.ReplaceAllString(c.DriftAlertWebhookURL, "$1/****")
+
+	// plain has the fields but not the String method
+	type plain Config
+	return fmt.Sprintf("%+v", plain(c))
+}
+
+// maskDSN hides the password of a MySQL DSN. The driver's parser is used because the password
+// runs up to the last @ and may contain @ and / itself. A DSN it cannot parse is hidden whole.
+func maskDSN(dsn string) string {
+	if dsn == "" {
+		return ""
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "****"
+	}
+	if cfg.Passwd == "" {
+		return dsn
+	}
+	cfg.Passwd = "****"
+	return cfg.FormatDSN()
+}
+
+// maskURL hides the password of a URL, @ and / in it have to be percent encoded for the URL to work.
+// A URL that does not parse is hidden whole.
+func maskURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "****"
+	}
+	return u.Redacted()
+}
+
+// maskSecret keeps only the last four characters of long secrets
+func maskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) < 12 {
+		return "****"
+	}
+	return "****" + secret[len(secret)-4:]
+}
+
+// OnLambda reports whether the process runs inside AWS Lambda
+func OnLambda() bool {
+	return os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != ""
+}
+
+// defaultBlobStore is s3 on Lambda, where the handler and the worker do not share a filesystem
+func defaultBlobStore() string {
+	if OnLambda() {
+		return "s3"
+	}
+	return "local"
+}
+
 func LoadConfig() {
 
 	AppConfig = Config{
-		ServerIP:     getEnv("SERVER_IP", "127.0.0.1"),
-		ServerPort:   getEnv("SERVER_PORT", "54321"),
-		LogFile:      getEnv("LOG_FILE", "logs/app.log"),
-		AWSRegion:    getEnv("AWS_REGION", "us-east-1"),
-		AWSAccessKey: getEnv("CUSTOM_AWS_ACCESS_KEY", ""),
-		AWSSecretKey: getEnv("CUSTOM_AWS_SECRET_KEY", ""),
-		SQSQueueURL:  getEnv("SQS_QUEUE_URL", ""),
-		MySQLDSN:     getEnv("MYSQL_DSN", ""),
+		ServerIP:      getEnv("SERVER_IP", "127.0.0.1"),
+		ServerPort:    getEnv("SERVER_PORT", "54321"),
+		LogFile:       getEnv("LOG_FILE", "logs/app.log"),
+		LogLevel:      getEnv("LOG_LEVEL", "info"),
+		LogFormat:     getEnv("LOG_FORMAT", "text"),
+		LogFullBodies: getEnvBool("LOG_FULL_BODIES", false),
+		LogBodyLimit:  getEnvInt("LOG_BODY_LIMIT", 200),
+		AWSRegion:     getEnv("AWS_REGION", "us-east-1"),
+		AWSAccessKey:  getEnv("CUSTOM_AWS_ACCESS_KEY", ""),
+		AWSSecretKey:  getEnv("CUSTOM_AWS_SECRET_KEY", ""),
+		SQSQueueURL:   getEnv("SQS_QUEUE_URL", ""),
+		MySQLDSN:      getEnv("MYSQL_DSN", ""),
+		BlobStore:     getEnv("BLOB_STORE", defaultBlobStore()),
+		BlobLocalDir:  getEnv("BLOB_LOCAL_DIR", "storage/blobs"),
+		BlobS3Bucket:  getEnv("BLOB_S3_BUCKET", ""),
+		BlobS3Prefix:  getEnv("BLOB_S3_PREFIX", "email-bodies"),
+		RoutesFile:    getEnv("ROUTES_FILE", ""),
+		DefaultRoute:  getEnv("DEFAULT_ROUTE", ""),
+
+		BlobOffloadThreshold: getEnvInt("BLOB_OFFLOAD_THRESHOLD", 64*1024),
+
+		WorkerConcurrency:    getEnvInt("WORKER_CONCURRENCY", 4),
+		WorkerDeadlineMargin: getEnvDuration("WORKER_DEADLINE_MARGIN", 10*time.Second),
+
+		WorkerVisibilityTimeout: getEnvDuration("WORKER_VISIBILITY_TIMEOUT", 5*time.Minute),
+
+		GeocoderProvider:   getEnv("GEOCODER_PROVIDER", "pelias"),
+		GeocoderURL:        getEnv("GEOCODER_URL", "http://207.244.250.222:4000"),
+		GeocoderTimeout:    getEnvDuration("GEOCODER_TIMEOUT", 10*time.Second),
+		GeocoderStaticFile: getEnv("GEOCODER_STATIC_FILE", ""),
+
+		GeocodeCacheSize:        getEnvInt("GEOCODE_CACHE_SIZE", 1000),
+		GeocodeCacheTTL:         getEnvDuration("GEOCODE_CACHE_TTL", 30*24*time.Hour),
+		GeocodeCacheNegativeTTL: getEnvDuration("GEOCODE_CACHE_NEGATIVE_TTL", time.Hour),
+
+		RegeocodeInterval:    getEnvDuration("REGEOCODE_INTERVAL", time.Minute),
+		RegeocodeBatchSize:   getEnvInt("REGEOCODE_BATCH_SIZE", 50),
+		RegeocodeBaseDelay:   getEnvDuration("REGEOCODE_BASE_DELAY", time.Minute),
+		RegeocodeMaxDelay:    getEnvDuration("REGEOCODE_MAX_DELAY", 6*time.Hour),
+		RegeocodeMaxAttempts: getEnvInt("REGEOCODE_MAX_ATTEMPTS", 10),
+
+		RouterProvider: getEnv("ROUTER_PROVIDER", ""),
+		RouterURL:      getEnv("ROUTER_URL", ""),
+		CircuityFactor: getEnvFloat("CIRCUITY_FACTOR", 1.2),
+
+		PlatformSendOrderURL: getEnv("PLATFORM_SEND_ORDER_URL", "https://platform.hfield.net/api/send_order"),
+		OutboxPollInterval:   getEnvDuration("OUTBOX_POLL_INTERVAL", 10*time.Second),
+		OutboxBatchSize:      getEnvInt("OUTBOX_BATCH_SIZE", 50),
+		OutboxBaseDelay:      getEnvDuration("OUTBOX_BASE_DELAY", 5*time.Second),
+		OutboxMaxDelay:       getEnvDuration("OUTBOX_MAX_DELAY", 30*time.Minute),
+		OutboxMaxAttempts:    getEnvInt("OUTBOX_MAX_ATTEMPTS", 12),
+
+		// The worker keeps the port prometheus.yml scrapes, the server gets its own so both can run on one host
+		MetricsAddr:          getEnv("METRICS_ADDR", ":2112"),
+		ServerMetricsAddr:    getEnv("SERVER_METRICS_ADDR", ":2113"),
+		MetricsBackend:       getEnv("METRICS_BACKEND", "emf,prometheus"),
+		MetricsNamespace:     getEnv("METRICS_NAMESPACE", "SQSWorkerMetrics"),
+		MetricsFlushInterval: getEnvDuration("METRICS_FLUSH_INTERVAL", 10*time.Second),
+
+		FieldStatsRetention: getEnvDuration("FIELD_STATS_RETENTION", 30*24*time.Hour),
+
+		DriftInterval:            getEnvDuration("DRIFT_INTERVAL", 15*time.Minute),
+		DriftWindow:              getEnvDuration("DRIFT_WINDOW", 6*time.Hour),
+		DriftBaseline:            getEnvDuration("DRIFT_BASELINE", 7*24*time.Hour),
+		DriftMinSamples:          getEnvInt("DRIFT_MIN_SAMPLES", 20),
+		DriftMinStructureSamples: getEnvInt("DRIFT_MIN_STRUCTURE_SAMPLES", 3),
+		DriftRateDrop:            getEnvFloat("DRIFT_RATE_DROP", 0.2),
+		DriftAlertCooldown:       getEnvDuration("DRIFT_ALERT_COOLDOWN", 24*time.Hour),
+		DriftAlertWebhookURL:     getEnv("DRIFT_ALERT_WEBHOOK_URL", ""),
+		DriftAlertSecret:         getEnv("DRIFT_ALERT_SECRET", ""),
+		DriftSampleRetention:     getEnvDuration("DRIFT_SAMPLE_RETENTION", 30*24*time.Hour),
+
+		TracingExporter:    getEnv("TRACING_EXPORTER", "none"),
+		TracingEndpoint:    getEnv("TRACING_OTLP_ENDPOINT", "localhost:4318"),
+		TracingInsecure:    getEnvBool("TRACING_OTLP_INSECURE", true),
+		TracingServiceName: getEnv("TRACING_SERVICE_NAME", "parser-landstar"),
+		TracingSampleRatio: getEnvFloat("TRACING_SAMPLE_RATIO", 1),
+
+		HealthCheckTimeout: getEnvDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+
+		AdminToken: getEnv("ADMIN_TOKEN", ""),
 	}
-	logrus.Infof("Loaded configuration: %+v", AppConfig)
+	logrus.Infof("Loaded configuration: %s", AppConfig)
 
 }
 
@@ -42,3 +266,59 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// Helper function to read a float environment variable or return a default value
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		logrus.Warnf("Invalid number for %s: %q, using %g", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// Helper function to read a boolean environment variable or return a default value
+func getEnvBool(key string, defaultValue bool) bool {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		logrus.Warnf("Invalid boolean for %s: %q, using %t", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// Helper function to read an integer environment variable or return a default value
+func getEnvInt(key string, defaultValue int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		logrus.Warnf("Invalid integer for %s: %q, using %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// Helper function to read a duration environment variable (e.g. "10s") or return a default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		logrus.Warnf("Invalid duration for %s: %q, using %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}